@@ -1,210 +1,202 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"time"
 
-	"github.com/getsentry/sentry-go"
-	"github.com/gorilla/websocket"
-	"gopkg.in/yaml.v3"
+	"misskey-timeline-watchdog/watchdog"
 )
 
-type Config struct {
-	Target struct {
-		Domain string `yaml:"domain"`
-		URL    string `yaml:"url"`
-	} `yaml:"target"`
-	Timeout  int    `yaml:"timeout"`  // Seconds
-	Cooldown int    `yaml:"cooldown"` // Seconds
-	Command  string `yaml:"command"`
-	Sentry   struct {
-		DSN string `yaml:"dsn"`
-	} `yaml:"sentry"`
+// exitSampleCreated is the exit status after a sample was written for a missing configuration file,
+// so first-run scripts can tell it apart from a failure (1) or a usage error (2).
+const exitSampleCreated = 3
+
+// hiddenFlags are left out of the usage message, as they are meant for validating a deployment, not for everyday use.
+var hiddenFlags = []string{"self-test"}
+
+// usage prints the flags like the default usage message of the flag package, leaving out hiddenFlags.
+func usage() {
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(hiddenFlags, f.Name) {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue // Not the parsed value
+		}
+	})
+	fmt.Fprintf(visible.Output(), "Usage of %s:\n", os.Args[0])
+	visible.PrintDefaults()
 }
 
-const (
-	DefaultPath      = "/streaming"
-	SubscribePayload = `{"type":"connect","body":{"channel":"globalTimeline","id":"1","params":{"withRenotes":true,"minimize":true}}}`
-
-	DefaultConfigTemplate = `target:
-  domain: '' # Required (e.g., misskey.io)
-  # url: '' # Optional: Overrides domain if set (e.g., wss://misskey.io/streaming)
-timeout: 10
-cooldown: 300 # Seconds to wait before reconnecting after a failure
-command: ./script.sh
-sentry:
-  dsn: '' # e.g. https://public@sentry.example.com/1
-`
-)
+// configPaths collects the repeatable -config flag. Later files are merged over earlier ones.
+type configPaths []string
 
-func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	var cfg Config
-	err = yaml.Unmarshal(data, &cfg)
-	if err != nil {
-		return nil, err
-	}
-	return &cfg, nil
+func (p *configPaths) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *configPaths) Set(value string) error {
+	*p = append(*p, value)
+	return nil
 }
 
-func getTargetURL(cfg *Config) (string, error) {
-	if cfg.Target.URL != "" {
-		return cfg.Target.URL, nil
+// writeSampleConfig writes DefaultConfigTemplate to path with the octal mode, creating parent directories as needed.
+func writeSampleConfig(path, mode string) error {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0o777 {
+		return fmt.Errorf("-sample-mode: %q is not an octal file mode such as 0600", mode)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
 	}
-	if cfg.Target.Domain != "" {
-		cleanDomain := strings.TrimSuffix(strings.TrimPrefix(cfg.Target.Domain, "https://"), "/")
-		return fmt.Sprintf("wss://%s%s", cleanDomain, DefaultPath), nil
+	// The sample holds no secrets yet, but the DSN and tokens usually end up in it
+	if perm&0o004 != 0 {
+		log.Printf("Warning: %s will be world-readable (mode %04o); consider -sample-mode 0600 before adding secrets to it.", path, perm)
 	}
-	return "", fmt.Errorf("target.domain or target.url must be specified in the configuration file")
+	return os.WriteFile(path, []byte(watchdog.DefaultConfigTemplate), os.FileMode(perm))
 }
 
-func logPrintf(format string, v ...interface{}) {
-	msg := fmt.Sprintf(format, v...)
-	log.Println(msg)
+// runValidate reports every problem found in the configuration files and returns the process exit code.
+func runValidate(paths []string) int {
+	path := strings.Join(paths, ", ")
+	cfg, err := watchdog.LoadConfig(paths...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to load configuration:\n", path)
+		printErrors(path, err)
+		return 1
+	}
 
-	// CHANGED: Use CaptureMessage instead of Breadcrumb
-	sentry.CaptureMessage(msg)
+	err = cfg.Validate()
+	if err == nil {
+		fmt.Printf("%s: configuration is valid\n", path)
+		return 0
+	}
+	printErrors(path, err)
+	return 1
 }
 
-func logFatalf(format string, v ...interface{}) {
-	msg := fmt.Sprintf(format, v...)
-	log.Println("FATAL:", msg)
-
-	sentry.CaptureMessage("FATAL: " + msg)
-	sentry.Flush(5 * time.Second)
-
-	os.Exit(1)
+// printErrors writes every error joined into err to stderr on a line of its own.
+func printErrors(path string, err error) {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, e)
+	}
 }
 
 func main() {
-	configPath := flag.String("config", "config.yaml", "Path to the configuration file")
+	var configPaths configPaths
+	flag.Var(&configPaths, "config", "Path or http(s) URL of the configuration file (re-read on SIGHUP); repeat to merge later files over earlier ones (default config.yaml)")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration (secrets redacted) and exit")
+	validateOnly := flag.Bool("validate", false, "Validate the configuration file and exit without monitoring")
+	printSchema := flag.Bool("print-schema", false, "Print the JSON Schema of the configuration file (for editors and linters) and exit")
+	sampleMode := flag.String("sample-mode", "0644", "Octal file mode of a generated sample configuration (e.g. 0600 to keep the DSN and tokens private)")
+	noWriteSample := flag.Bool("no-write-sample", false, "Treat a missing configuration file as a fatal error instead of writing a sample to its path")
+	check := flag.Bool("check", false, "Connect and subscribe to every target once (retrying per preflight_attempts), report the results and exit")
+	selfTest := flag.Bool("self-test", false, "Simulate a failure of the first target against a fake server, run the command for real and report the results")
+	flag.Usage = usage
 	flag.Parse()
 
-	if _, err := os.Stat(*configPath); os.IsNotExist(err) {
-		_ = os.WriteFile(*configPath, []byte(DefaultConfigTemplate), 0644)
-		log.Fatalf("Configuration file not found. Created sample at: %s", *configPath)
+	if *printSchema {
+		os.Stdout.Write(watchdog.ConfigSchema())
+		return
 	}
-
-	cfg, err := loadConfig(*configPath)
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	if len(configPaths) == 0 {
+		configPaths = []string{"config.yaml"}
+	}
+	if *validateOnly {
+		os.Exit(runValidate(configPaths))
 	}
 
-	if cfg.Sentry.DSN != "" {
-		err := sentry.Init(sentry.ClientOptions{
-			Dsn:              cfg.Sentry.DSN,
-			TracesSampleRate: 1.0,
-			AttachStacktrace: true,
-		})
-		if err != nil {
-			log.Printf("Sentry initialization failed: %v", err)
-		} else {
-			logPrintf("Sentry initialized successfully.")
-			defer sentry.Flush(2 * time.Second)
+	// A sample is only generated for a single file, as a missing layer is more likely a typo
+	if path := configPaths[0]; len(configPaths) == 1 && !watchdog.IsRemoteConfig(path) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if *noWriteSample {
+				log.Fatalf("Configuration file not found: %s", path)
+			}
+			if err := writeSampleConfig(path, *sampleMode); err != nil {
+				log.Fatalf("Configuration file not found, and the sample could not be written: %v", err)
+			}
+			log.Printf("Configuration file not found. Created sample at: %s", path)
+			log.Printf("Next steps: set target.domain and command in it, check it with -validate -config %s, then start the watchdog again.", path)
+			os.Exit(exitSampleCreated)
 		}
 	}
 
-	targetURL, err := getTargetURL(cfg)
+	cfg, sources, err := watchdog.LoadLayeredConfig(configPaths)
 	if err != nil {
-		logFatalf("Configuration Error: %v", err)
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
-
-	cooldownDuration := time.Duration(cfg.Cooldown) * time.Second
-	if cfg.Cooldown <= 0 {
-		cooldownDuration = 5 * time.Minute
+	if *printConfig {
+		if len(configPaths) == 1 {
+			sources = nil // Every value comes from the one file or its defaults
+		}
+		out, err := watchdog.RenderConfig(cfg.Redacted(), sources)
+		if err != nil {
+			log.Fatalf("Failed to render configuration: %v", err)
+		}
+		fmt.Print(string(out))
+		return
 	}
+	watchdog.SetupLogger(cfg)
 
-	logPrintf("Configuration Loaded. Target: %s, Timeout: %ds, Cooldown: %s", targetURL, cfg.Timeout, cooldownDuration)
-
-	for {
-		// A. Start Monitoring
-		err := startMonitoringSession(targetURL, cfg)
-
-		// B. Report Crash to Sentry (Error Level)
-		logPrintf("Monitor session ended with error: %v", err)
-		sentry.WithScope(func(scope *sentry.Scope) {
-			scope.SetLevel(sentry.LevelError)
-			sentry.CaptureException(err)
-		})
-
-		// C. Execute command
-		logPrintf("Attempting to execute command...")
-		executeCommandAndReport(cfg.Command)
-
-		// D. Cooldown
-		logPrintf(">>> Waiting %s before reconnecting...", cooldownDuration)
-		sentry.Flush(5 * time.Second)
-
-		time.Sleep(cooldownDuration)
-
-		logPrintf(">>> Cooldown finished. Retrying connection...")
+	if watchdog.SetupSentry(cfg) {
+		defer watchdog.FlushSentry()
 	}
-}
-
-func startMonitoringSession(url string, cfg *Config) error {
-	logPrintf("Connecting to Misskey Streaming API...")
 
-	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	w, err := watchdog.New(cfg)
 	if err != nil {
-		return fmt.Errorf("connection failed: %w", err)
+		watchdog.Fatalf("Configuration Error: %v", err)
 	}
-	defer c.Close()
-
-	if err := c.WriteMessage(websocket.TextMessage, []byte(SubscribePayload)); err != nil {
-		return fmt.Errorf("subscribe request failed: %w", err)
+	if *check {
+		ctx, stop := signal.NotifyContext(context.Background(), shutdownSignals...)
+		code := watchdog.RunPreflight(ctx, cfg)
+		stop()
+		os.Exit(code)
+	}
+	if *selfTest {
+		os.Exit(watchdog.RunSelfTest(cfg))
 	}
 
-	logPrintf("Monitoring started (Listening for messages)...")
-
-	timeoutDuration := time.Duration(cfg.Timeout) * time.Second
+	ctx, stop := signal.NotifyContext(context.Background(), shutdownSignals...)
+	defer stop()
+	if len(reloadSignals) > 0 {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, reloadSignals...)
+		go awaitReload(ctx, w, configPaths, reload)
+	}
+	if err := w.Run(ctx); err != nil {
+		watchdog.Fatalf("%v", err)
+	}
+}
 
+// awaitReload re-reads the configuration on every reload signal and hands it to w once it validates.
+// An invalid configuration is reported and ignored, keeping the running one. It returns when ctx is cancelled.
+func awaitReload(ctx context.Context, w *watchdog.Watchdog, paths []string, reload <-chan os.Signal) {
 	for {
-		if err := c.SetReadDeadline(time.Now().Add(timeoutDuration)); err != nil {
-			return fmt.Errorf("failed to set read deadline: %w", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
 		}
 
-		_, _, err := c.ReadMessage()
+		watchdog.Printf("Reloading configuration from %s...", strings.Join(paths, ", "))
+		cfg, err := watchdog.LoadConfig(paths...)
+		if err == nil {
+			err = w.Reload(cfg)
+		}
 		if err != nil {
-			return fmt.Errorf("read timeout or disconnection: %w", err)
+			watchdog.Errorf("Configuration reload failed, keeping the current configuration: %v", err)
 		}
 	}
 }
-
-func executeCommandAndReport(commandStr string) {
-	parts := strings.Fields(commandStr)
-	if len(parts) == 0 {
-		logPrintf("Error: Recovery command string is empty")
-		return
-	}
-
-	cmd := exec.Command(parts[0], parts[1:]...)
-	outputBytes, err := cmd.CombinedOutput()
-	output := string(outputBytes)
-
-	log.Printf("Command Output:\n%s", output)
-
-	if err != nil {
-		sentry.WithScope(func(scope *sentry.Scope) {
-			scope.SetLevel(sentry.LevelFatal)
-			scope.SetExtra("command_output", output)
-			sentry.CaptureException(fmt.Errorf("command failed: %w", err))
-		})
-
-		log.Printf("command failed: %v", err)
-	} else {
-		sentry.WithScope(func(scope *sentry.Scope) {
-			scope.SetLevel(sentry.LevelInfo)
-			scope.SetExtra("command_output", output)
-			sentry.CaptureMessage(fmt.Sprintf("command executed successfully: %s", parts[0]))
-		})
-		log.Println("command executed successfully.")
-	}
-}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals stop the watchdog gracefully.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// reloadSignals make the watchdog re-read its configuration.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// shutdownSignals stop the watchdog gracefully. Windows only delivers Ctrl+C / Ctrl+Break as os.Interrupt.
+var shutdownSignals = []os.Signal{os.Interrupt}
+
+// reloadSignals is empty, as Windows has no SIGHUP; the configuration is only read at startup.
+var reloadSignals []os.Signal
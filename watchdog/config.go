@@ -0,0 +1,897 @@
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
+)
+
+// TargetURL is one node of a failover list, written either as a plain URL or as {url, weight}.
+type TargetURL struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight,omitempty"` // Relative share of attempts with the weighted selection (default 1)
+}
+
+func (u *TargetURL) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&u.URL)
+	}
+	type plain TargetURL
+	return value.Decode((*plain)(u))
+}
+
+type Target struct {
+	Name           string      `yaml:"name"`        // Optional: Identifies the target in logs, metrics labels, notifications and Sentry (default: the URL's host)
+	Description    string      `yaml:"description"` // Optional: Free text reported in /status and notifications
+	Domain         string      `yaml:"domain"`
+	URL            string      `yaml:"url"`
+	URLs           []TargetURL `yaml:"urls"`       // Optional: Failover nodes used instead of url/domain
+	Selection      string      `yaml:"selection"`  // ordered, random or weighted
+	Command        string      `yaml:"command"`    // Optional: Overrides the top-level command for this target
+	NoteTypes      []string    `yaml:"note_types"` // Optional: Only notes with these visibilities count as activity
+	Timeout        int         `yaml:"timeout"`    // Optional: Overrides the top-level timeout (seconds)
+	Cooldown       int         `yaml:"cooldown"`   // Optional: Overrides the top-level cooldown (seconds)
+	BasicAuth      BasicAuth   `yaml:"basic_auth,omitempty"`
+	Token          string      `yaml:"token"`           // Optional: Access token for the streaming API
+	TokenPlacement string      `yaml:"token_placement"` // query (i= parameter) or header (Authorization: Bearer)
+	ParallelDial   int         `yaml:"parallel_dial"`   // Optional: Failover nodes dialed at once, keeping the first to connect (0 or 1 = one at a time)
+	SubscribeID    string      `yaml:"subscribe_id"`    // Optional: id of the channel subscription, which incoming channel events are matched against (default 1)
+}
+
+// BasicAuth holds HTTP Basic credentials sent on the WebSocket handshake.
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type Config struct {
+	Target         Target   `yaml:"target"`
+	Targets        []Target `yaml:"targets"`         // Optional: Monitors several instances at once; takes precedence over target
+	Timeout        int      `yaml:"timeout"`         // Seconds
+	HeartbeatTypes []string `yaml:"heartbeat_types"` // Frame or channel event types that count as activity
+	LivenessMode   string   `yaml:"liveness_mode"`   // heartbeat, any_message or notes_only
+	ParseDepth     string   `yaml:"parse_depth"`     // full or shallow (notes are not decoded)
+	StartupGrace   int      `yaml:"startup_grace"`   // Extra seconds allowed for the first message of each session
+	StartupDelay   int      `yaml:"startup_delay"`   // Seconds to wait before the first connection after the process starts
+	StartupJitter  int      `yaml:"startup_jitter"`  // Up to this many random seconds added to startup_delay
+	PingInterval   int      `yaml:"ping_interval"`   // Seconds; 0 disables ping/pong
+	PongWait       int      `yaml:"pong_wait"`       // Seconds allowed for a pong after each ping
+	WriteTimeout   int      `yaml:"write_timeout"`   // Seconds allowed for each frame sent to the server
+	Cooldown       int      `yaml:"cooldown"`        // Seconds
+	ReconnectDelay int      `yaml:"reconnect_delay"` // Seconds to wait after the server cleanly closes a healthy session
+	Command        string   `yaml:"command"`
+	CommandAsync   bool     `yaml:"command_async"` // Run the command in the background while monitoring continues
+	CommandShell   bool     `yaml:"command_shell"` // Run the command through /bin/sh -c (cmd /C on Windows)
+	CommandStdin   string   `yaml:"command_stdin"` // Written to the command's standard input
+	CommandUser    string   `yaml:"command_user"`  // Unix user (name or UID) to run the command as
+	CommandGroup   string   `yaml:"command_group"` // Unix group (name or GID) to run the command as
+	NotifyOnly     bool     `yaml:"notify_only"`   // Report failures without ever running the command
+	Quorum         float64  `yaml:"quorum"`        // Fraction of targets that must be down before the command runs (0 = any)
+	Schedule       string   `yaml:"schedule"`      // Cron expression for the minutes to monitor; empty means always
+	MaxRuntime     int      `yaml:"max_runtime"`   // Seconds after which the process exits cleanly; 0 = unlimited
+
+	ReconnectAttempts   int     `yaml:"reconnect_attempts"`    // Failed sessions retried quietly before the command runs
+	RecoveryMinDuration int     `yaml:"recovery_min_duration"` // Seconds a session must last before the target counts as recovered
+	MalformedFrameRatio float64 `yaml:"malformed_frame_ratio"` // Fraction of unparsable frames that ends the session
+	RequireCommand      bool    `yaml:"require_command"`       // Fail validation instead of warning when the command binary is not found
+	DNSCooldown         int     `yaml:"dns_cooldown"`          // Seconds to wait instead of cooldown after a DNS lookup failure that ran no command
+	RetryableStatuses   []int   `yaml:"retryable_statuses"`    // Handshake HTTP statuses retried after reconnect_delay, without running the command
+	PrewarmInterval     int     `yaml:"prewarm_interval"`      // Seconds between the DNS lookups and TLS handshakes made ahead of reconnections; 0 disables
+	SubscribeAckTimeout int     `yaml:"subscribe_ack_timeout"` // Seconds allowed for the subscription to be acknowledged; 0 disables
+	VerifyAfterCommand  int     `yaml:"verify_after_command"`  // Seconds allowed for a note to arrive after the command; 0 disables the check
+	VerifyRerun         bool    `yaml:"verify_rerun"`          // Run the command once more when that check fails
+	PreflightAttempts   int     `yaml:"preflight_attempts"`    // Connection attempts per target made by -check before it fails
+	PreflightInterval   int     `yaml:"preflight_interval"`    // Seconds between those attempts
+
+	CommandsByCategory map[string]string `yaml:"commands_by_category"` // Failure category -> command; an empty one runs nothing
+
+	Dialer struct {
+		ReadBufferSize   int      `yaml:"read_buffer_size"`  // Bytes
+		WriteBufferSize  int      `yaml:"write_buffer_size"` // Bytes
+		HandshakeTimeout int      `yaml:"handshake_timeout"` // Seconds
+		LocalAddress     string   `yaml:"local_address"`     // Source IP for outgoing connections
+		KeepAlive        int      `yaml:"keep_alive"`        // Seconds between TCP keep-alive probes; 0 = Go default, -1 = disabled
+		KeepAliveCount   int      `yaml:"keep_alive_count"`  // Unanswered probes before the connection is dropped; 0 = Go default
+		Network          string   `yaml:"network"`           // tcp, tcp4 or tcp6
+		Subprotocols     []string `yaml:"subprotocols"`      // Sec-WebSocket-Protocol values to offer
+	} `yaml:"dialer"`
+	CommandExitCodes struct {
+		Reconnect        int `yaml:"reconnect"`         // Exit code after which no cooldown is needed; 0 disables
+		ExtendCooldown   int `yaml:"extend_cooldown"`   // Exit code after which extended_cooldown replaces the cooldown; 0 disables
+		ExtendedCooldown int `yaml:"extended_cooldown"` // Seconds; 0 = twice the cooldown
+	} `yaml:"command_exit_codes"`
+	Maintenance struct {
+		CloseCodes []int    `yaml:"close_codes"` // WebSocket close codes that mean maintenance
+		Patterns   []string `yaml:"patterns"`    // Case-insensitive text that means maintenance in close reasons, error frames or handshake responses
+		Cooldown   int      `yaml:"cooldown"`    // Seconds to wait before reconnecting during maintenance
+	} `yaml:"maintenance"`
+	Throughput struct {
+		Window    int     `yaml:"window"`     // Seconds per rate sample
+		Baseline  int     `yaml:"baseline"`   // Seconds of samples averaged as the baseline
+		DropRatio float64 `yaml:"drop_ratio"` // Fraction of the baseline below which a window counts as a drop; 0 disables
+	} `yaml:"throughput"`
+	Reload struct {
+		Graceful bool `yaml:"graceful"` // Let the current sessions end on their own before the reloaded configuration takes over
+		Timeout  int  `yaml:"timeout"`  // Seconds to wait for them before disconnecting anyway
+	} `yaml:"reload"`
+	Notify struct {
+		Webhooks []Webhook           `yaml:"webhooks"`
+		Routes   map[string][]string `yaml:"routes"`  // Failure category -> webhook names
+		Default  []string            `yaml:"default"` // Webhook names for categories without a route
+	} `yaml:"notify"`
+	HTTP struct {
+		Listen            string `yaml:"listen"`             // Empty disables the HTTP server
+		HealthPolicy      string `yaml:"health_policy"`      // all, any or majority
+		HealthOKStatus    int    `yaml:"health_ok_status"`   // HTTP status of /healthz when passing
+		HealthFailStatus  int    `yaml:"health_fail_status"` // HTTP status of /healthz when failing
+		HealthBody        string `yaml:"health_body"`        // text/template for the /healthz body, executed with the /status data
+		HealthContentType string `yaml:"health_content_type"`
+		EventsSize        int    `yaml:"events_size"` // Entries kept for /events
+	} `yaml:"http"`
+	Log struct {
+		Level      string `yaml:"level"`       // info or debug
+		Format     string `yaml:"format"`      // text or json
+		Timezone   string `yaml:"timezone"`    // IANA name such as Asia/Tokyo; empty uses local time
+		TimeFormat string `yaml:"time_format"` // Go time layout, rfc3339 or rfc3339nano
+	} `yaml:"log"`
+	Sentry struct {
+		DSN            string            `yaml:"dsn"`
+		DSNs           []string          `yaml:"dsns"`            // Further projects that receive the same events
+		UseBreadcrumbs bool              `yaml:"use_breadcrumbs"` // Attach routine logs to the next error instead of sending them as events
+		AttachOutput   bool              `yaml:"attach_output"`   // Send command output as an attachment instead of a (truncated) extra
+		Tracing        bool              `yaml:"tracing"`         // Send a performance trace for every command run
+		FlushTimeout   int               `yaml:"flush_timeout"`   // Seconds to wait for queued events before exiting or reconnecting
+		SeverityMap    map[string]string `yaml:"severity_map"`    // Failure category -> Sentry level of its session events
+	} `yaml:"sentry"`
+}
+
+const (
+	DefaultPath             = "/streaming"
+	DefaultCooldown         = 5 * time.Minute
+	DefaultPongWait         = 5 * time.Second
+	DefaultWriteTimeout     = 10 * time.Second
+	DefaultReconnectDelay   = time.Second
+	DefaultDNSCooldown      = 30 * time.Second
+	DefaultPreflightWait    = 5 * time.Second
+	DefaultBufferSize       = 4096
+	DefaultHandshakeTimeout = 45 * time.Second
+	DefaultNetwork          = "tcp"
+	DefaultLogLevel         = "info"
+	DefaultLogFormat        = "text"
+	DefaultLogTimeFormat    = "2006/01/02 15:04:05" // Matches the standard library's log prefix
+
+	DefaultHealthBody        = "{{.Status}}\n"
+	DefaultHealthContentType = "text/plain; charset=utf-8"
+	DefaultEventsSize        = 100
+
+	DefaultSentryFlushTimeout = 5 * time.Second
+
+	DefaultMalformedFrameRatio = 0.5
+	DefaultMaintenanceCooldown = 30 * time.Minute
+
+	DefaultThroughputWindow   = time.Minute
+	DefaultThroughputBaseline = time.Hour
+
+	DefaultReloadTimeout = 5 * time.Minute
+
+	MinTimeout  = time.Second // A shorter read deadline expires before any message can arrive
+	MinCooldown = time.Second // Floor for every wait before reconnecting, so a failing target can't spin the CPU
+
+	DefaultConfigFetchTimeout = 10 * time.Second
+	MaxConfigSize             = 1 << 20 // Largest remote configuration accepted, in bytes
+
+	RedactedValue = "[REDACTED]"
+
+	TokenPlacementQuery  = "query"
+	TokenPlacementHeader = "header"
+
+	DefaultConfigTemplate = `target:
+  domain: '' # Required (e.g., misskey.io)
+  # url: '' # Optional: Overrides domain if set (e.g., wss://misskey.io/streaming)
+  # For Misskey on a local Unix socket, use ws+unix://<absolute socket path>:<request path>,
+  # e.g. ws+unix:///run/misskey/misskey.sock:/streaming (proxy settings are not used for it).
+  # urls: # Optional: Failover nodes to connect to instead of url/domain, switching on each reconnect
+  #   - wss://node1.misskey.io/streaming
+  #   - url: wss://node2.misskey.io/streaming
+  #     weight: 3 # Relative share of attempts with selection: weighted (default 1)
+  # selection: ordered # How the next node is chosen: ordered, random or weighted
+  # parallel_dial: 0 # Dial this many nodes at once, starting with the chosen one, and keep the first to connect (0 = disabled)
+  # subscribe_id: '1' # id sent with the channel subscription; channel events with another id are ignored
+  # command: '' # Optional: Overrides the top-level command for this target
+  # note_types: [public, home] # Optional: Only these note visibilities count as activity (default: heartbeat_types)
+  # timeout: 60 # Optional: Overrides the top-level timeout for this target
+  # cooldown: 600 # Optional: Overrides the top-level cooldown for this target
+  # token: '' # Optional: Access token, for instances that require authentication on the streaming API
+  # token_placement: query # Send the token as the i= query parameter (query) or as Authorization: Bearer (header)
+  # basic_auth: # Optional: HTTP Basic credentials for instances behind an auth gate (e.g. staging)
+  #   username: ''
+  #   password: ''
+# targets: # Optional: Monitor several instances at once (takes precedence over target)
+#   - domain: misskey.io
+#     name: misskey-io # Optional: Shown in logs, metrics labels, notifications and Sentry instead of the URL (default: the host)
+#     description: Main instance # Optional: Reported in /status and notifications
+#     command: ./restart-misskey-io.sh
+#   - domain: example.com # Falls back to the top-level command, timeout and cooldown
+#     timeout: 120 # A quiet instance that needs a longer silence tolerance
+timeout: 10 # Seconds without a message before the session counts as failed (at least 1)
+heartbeat_types: [channel] # Messages that count as activity: frame types (channel = every channel event) or channel event types such as note or stats; ignored for targets with note_types
+liveness_mode: heartbeat # What keeps a session alive: heartbeat (heartbeat_types), any_message (every frame received, even unparsable ones) or notes_only (note events only)
+parse_depth: full # full, or shallow to skip decoding notes on busy timelines (about a third less parsing time per note) at the cost of note_types and duplicate detection
+subscribe_ack_timeout: 0 # Seconds after subscribing within which a "connected" or channel frame must arrive, to catch a silently ignored subscription; it replaces timeout until then (0 = disabled)
+startup_grace: 0 # Extra seconds allowed for the first message after subscribing, on top of timeout
+startup_delay: 0 # Seconds to wait before connecting for the first time, e.g. to spread out a fleet-wide deploy
+startup_jitter: 0 # Up to this many random seconds added to startup_delay
+preflight_attempts: 1 # Connection attempts per target that -check makes before failing, to tolerate an instance that is still starting
+preflight_interval: 5 # Seconds between those attempts
+ping_interval: 0 # Seconds between pings (0 = disabled)
+# pong_wait only checks that the socket is alive; timeout still governs how long the timeline may stay silent.
+pong_wait: 5 # Seconds a ping may go unanswered before the connection is dropped as dead
+write_timeout: 10 # Seconds allowed for sending the subscription or a ping before the connection is dropped as dead
+cooldown: 300 # Seconds to wait before reconnecting after a failure
+dns_cooldown: 30 # Seconds to wait instead of cooldown when the host name could not be resolved and no command ran, as DNS blips are usually transient
+reconnect_delay: 1 # Seconds to wait before reconnecting when the server closes a healthy session cleanly
+recovery_min_duration: 0 # Seconds a session must last (with activity) before the target counts as recovered and the failure streak resets
+reconnect_attempts: 0 # Failures in a row to retry quietly (with cooldown) before running the command
+retryable_statuses: [] # Handshake HTTP statuses (e.g. [502, 503, 504] from a reverse proxy during a restart) retried after reconnect_delay without running the command or counting towards reconnect_attempts
+prewarm_interval: 0 # Seconds between DNS lookups and TLS handshakes (no request is sent) with every node, so reconnections can skip the lookup and resume the TLS session (0 = disabled)
+command: ./script.sh
+command_async: false # Keep monitoring while the command runs (a new run is skipped while one is in progress)
+commands_by_category: {} # Optional: Run a different command per failure category, e.g. {timeout: ./restart.sh, auth: ''} ('' = none); other categories run command
+command_shell: false # Run the command through the shell (/bin/sh -c, or cmd /C on Windows) instead of splitting it on spaces
+command_stdin: '' # Optional: Text piped to the command's standard input (default: no input)
+command_user: '' # Optional: Run the command as this user (name or UID; requires root; default: the watchdog's user)
+command_group: '' # Optional: Run the command with this group (name or GID; default: command_user's primary group)
+require_command: false # Refuse to start when the command binary cannot be found (default: only warn; not checked with command_shell)
+verify_after_command: 0 # Seconds to keep trying to receive a note after the command, to confirm it worked (0 = no check; ignored with command_async)
+verify_rerun: false # Run the command once more when no note arrived in time
+command_exit_codes: # Optional: Let the command decide how long to wait before reconnecting (ignored with command_async)
+  reconnect: 0 # Exit code meaning the instance is fine and needs no cooldown, e.g. 10 (0 = disabled)
+  extend_cooldown: 0 # Exit code asking for a longer cooldown, e.g. 11 (0 = disabled)
+  extended_cooldown: 0 # Seconds to wait after extend_cooldown (default: twice the cooldown)
+notify_only: false # Detect and report failures, but never run the command; the only way to leave command empty
+malformed_frame_ratio: 0.5 # End the session when more than this fraction of frames is not valid JSON (1 = never)
+quorum: 0 # With multiple targets, only run the command when more than this fraction of them is down (e.g. 0.5)
+max_runtime: 0 # Seconds after which the watchdog exits cleanly, e.g. for periodic restarts by a supervisor (0 = unlimited)
+schedule: '' # Optional: Cron expression for the minutes to monitor (e.g., CRON_TZ=Asia/Tokyo * 9-17 * * 1-5; default: always)
+dialer:
+  read_buffer_size: 4096 # Bytes; raise for very busy timelines to reduce read syscalls
+  write_buffer_size: 4096 # Bytes; only the subscribe request is written, so the default is plenty
+  handshake_timeout: 45 # Seconds allowed for the WebSocket handshake
+  local_address: '' # Optional: Source IP to connect from on multi-homed hosts (e.g., 192.0.2.10)
+  network: tcp # tcp (IPv4 and IPv6), tcp4 (IPv4 only) or tcp6 (IPv6 only)
+  keep_alive: 0 # Seconds of idleness before, and between, TCP keep-alive probes (0 = Go default of 15s, -1 = disabled)
+  keep_alive_count: 0 # Unanswered keep-alive probes before the connection is considered dead (0 = Go default of 9)
+  subprotocols: [] # Optional: WebSocket subprotocols to request, for proxies that require one (default: none)
+maintenance: # Signs that the server is down for maintenance; the command is skipped since restarting won't help
+  close_codes: [1013] # WebSocket close codes (1013 = try again later)
+  patterns: [maintenance] # Case-insensitive text in close reasons, error frames or handshake responses
+  cooldown: 1800 # Seconds to wait before reconnecting during maintenance
+throughput: # Optional: Treat a sudden drop in the note rate as a failure, even while notes keep arriving
+  window: 60 # Seconds per rate sample
+  baseline: 3600 # Seconds of samples averaged as the baseline (checked once it is full)
+  drop_ratio: 0 # Fail when a window's rate falls below this fraction of the baseline, e.g. 0.2 (0 = disabled)
+reload: # How a configuration reload (SIGHUP) replaces the running monitors
+  graceful: false # Let each current session end on its own instead of disconnecting at once; targets whose session ended stay unmonitored until all have
+  timeout: 300 # Seconds to wait for that before disconnecting the remaining sessions
+notify: # Optional: Webhooks (JSON POST with a text field, e.g. Slack incoming webhooks) for failures and recoveries
+  webhooks: []
+  #   - name: slack
+  #     url: https://hooks.slack.com/services/...
+  #   - name: oncall
+  #     url: https://alerts.example.com/hook
+  routes: {} # Failure category -> webhooks, e.g. {auth: [oncall], timeout: [slack]}; categories: connection, auth, dns, subscribe, timeout, half_open, disconnect, closed, malformed, throughput, maintenance, recovered, unrecovered
+  default: [] # Webhooks for categories without a route
+http:
+  listen: '' # e.g. :8080 to serve /healthz, /livez, /readyz, /status, /events and /metrics
+  # Kubernetes: point livenessProbe at /livez (fails only when a monitor loop is stuck, so a restart helps) and
+  # readinessProbe at /readyz (fails while targets are not connected or no notes have arrived yet).
+  health_policy: all # /healthz and /readyz pass when all, any or a majority of targets are up
+  health_ok_status: 200 # HTTP status /healthz returns when passing
+  health_fail_status: 503 # HTTP status /healthz returns when failing
+  health_body: "{{.Status}}\n" # Go template for the /healthz body; has .Status, .Healthy and .Targets like /status
+  health_content_type: text/plain; charset=utf-8 # e.g. application/json with health_body: '{"ok":{{.Healthy}}}'
+  events_size: 100 # Recent connects, disconnects, command runs and errors listed as JSON at /events
+log:
+  level: info # info or debug
+  format: text # text, or json for one object per line with structured fields (e.g. the command's exit code) for log aggregators
+  timezone: '' # Optional: Time zone for log timestamps (e.g., UTC, Asia/Tokyo; default: local time)
+  time_format: '' # Optional: rfc3339, rfc3339nano or a Go layout (default: 2006/01/02 15:04:05)
+sentry:
+  dsn: '' # e.g. https://public@sentry.example.com/1
+  dsns: [] # Optional: Further DSNs (e.g. of another team's project) that receive the same events
+  use_breadcrumbs: false # Attach routine logs to the next error as breadcrumbs instead of sending each as an event
+  attach_output: false # Send the full command output as an attachment (up to 1 MiB) instead of an extra; uses attachment quota
+  tracing: false # Trace each command run; with http.listen set, /metrics links command durations to traces via exemplars
+  flush_timeout: 5 # Seconds to wait for queued events to be sent on shutdown, fatal errors and before reconnecting
+  severity_map: {} # Optional: Sentry level (debug, info, warning, error or fatal) per failure category, e.g. {closed: info, timeout: warning}; by default maintenance and clean closes are warnings, other failures errors
+`
+)
+
+// IsRemoteConfig reports whether path is an http(s) URL rather than a local file.
+func IsRemoteConfig(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// readConfigSource reads the configuration from a local file or, for an http(s) URL, downloads it.
+func readConfigSource(path string) ([]byte, error) {
+	if !IsRemoteConfig(path) {
+		return os.ReadFile(path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultConfigFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching configuration: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching configuration: unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxConfigSize+1)) // One more byte to tell a cut-off body apart
+	if err != nil {
+		return nil, fmt.Errorf("fetching configuration: %w", err)
+	}
+	if len(data) > MaxConfigSize {
+		return nil, errors.New("fetching configuration: configuration larger than 1 MiB")
+	}
+	return data, nil
+}
+
+// LoadConfig reads the configuration, merging each of paths over the previous ones.
+func LoadConfig(paths ...string) (*Config, error) {
+	cfg, _, err := LoadLayeredConfig(paths)
+	return cfg, err
+}
+
+// applyDefaults fills in the settings left unset in the configuration file.
+func (cfg *Config) applyDefaults() {
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = int(DefaultCooldown / time.Second)
+	}
+	if cfg.Maintenance.CloseCodes == nil {
+		cfg.Maintenance.CloseCodes = []int{websocket.CloseTryAgainLater}
+	}
+	if cfg.Maintenance.Patterns == nil {
+		cfg.Maintenance.Patterns = []string{"maintenance"}
+	}
+	if cfg.Maintenance.Cooldown == 0 {
+		cfg.Maintenance.Cooldown = int(DefaultMaintenanceCooldown / time.Second)
+	}
+	if cfg.Throughput.Window == 0 {
+		cfg.Throughput.Window = int(DefaultThroughputWindow / time.Second)
+	}
+	if cfg.Throughput.Baseline == 0 {
+		cfg.Throughput.Baseline = int(DefaultThroughputBaseline / time.Second)
+	}
+	if cfg.Reload.Timeout == 0 {
+		cfg.Reload.Timeout = int(DefaultReloadTimeout / time.Second)
+	}
+	if cfg.DNSCooldown == 0 {
+		cfg.DNSCooldown = int(DefaultDNSCooldown / time.Second)
+	}
+	if cfg.HeartbeatTypes == nil {
+		cfg.HeartbeatTypes = []string{"channel"}
+	}
+	if cfg.LivenessMode == "" {
+		cfg.LivenessMode = LivenessHeartbeat
+	}
+	if cfg.ParseDepth == "" {
+		cfg.ParseDepth = ParseFull
+	}
+	if cfg.PreflightAttempts == 0 {
+		cfg.PreflightAttempts = 1
+	}
+	if cfg.PreflightInterval == 0 {
+		cfg.PreflightInterval = int(DefaultPreflightWait / time.Second)
+	}
+	if cfg.ReconnectDelay == 0 {
+		cfg.ReconnectDelay = int(DefaultReconnectDelay / time.Second)
+	}
+	if cfg.PongWait == 0 {
+		cfg.PongWait = int(DefaultPongWait / time.Second)
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = int(DefaultWriteTimeout / time.Second)
+	}
+	if cfg.MalformedFrameRatio == 0 {
+		cfg.MalformedFrameRatio = DefaultMalformedFrameRatio
+	}
+	if cfg.Dialer.ReadBufferSize == 0 {
+		cfg.Dialer.ReadBufferSize = DefaultBufferSize
+	}
+	if cfg.Dialer.WriteBufferSize == 0 {
+		cfg.Dialer.WriteBufferSize = DefaultBufferSize
+	}
+	if cfg.Dialer.HandshakeTimeout == 0 {
+		cfg.Dialer.HandshakeTimeout = int(DefaultHandshakeTimeout / time.Second)
+	}
+	if cfg.Dialer.Network == "" {
+		cfg.Dialer.Network = DefaultNetwork
+	}
+	if cfg.HTTP.HealthPolicy == "" {
+		cfg.HTTP.HealthPolicy = HealthPolicyAll
+	}
+	if cfg.HTTP.HealthOKStatus == 0 {
+		cfg.HTTP.HealthOKStatus = http.StatusOK
+	}
+	if cfg.HTTP.HealthFailStatus == 0 {
+		cfg.HTTP.HealthFailStatus = http.StatusServiceUnavailable
+	}
+	if cfg.HTTP.HealthBody == "" {
+		cfg.HTTP.HealthBody = DefaultHealthBody
+	}
+	if cfg.HTTP.HealthContentType == "" {
+		cfg.HTTP.HealthContentType = DefaultHealthContentType
+	}
+	if cfg.Sentry.FlushTimeout == 0 {
+		cfg.Sentry.FlushTimeout = int(DefaultSentryFlushTimeout / time.Second)
+	}
+	if cfg.HTTP.EventsSize == 0 {
+		cfg.HTTP.EventsSize = DefaultEventsSize
+	}
+	if cfg.Log.Level == "" {
+		cfg.Log.Level = DefaultLogLevel
+	}
+	if cfg.Log.Format == "" {
+		cfg.Log.Format = DefaultLogFormat
+	}
+}
+
+// Redacted returns a copy of the configuration with secrets masked, suitable for printing.
+func (cfg *Config) Redacted() *Config {
+	c := *cfg
+	if c.Sentry.DSN != "" {
+		c.Sentry.DSN = RedactedValue
+	}
+	c.Sentry.DSNs = make([]string, len(cfg.Sentry.DSNs))
+	for i := range c.Sentry.DSNs {
+		c.Sentry.DSNs[i] = RedactedValue
+	}
+	c.Notify.Webhooks = make([]Webhook, len(cfg.Notify.Webhooks))
+	for i, w := range cfg.Notify.Webhooks {
+		w.URL = redactWebhookURL(w.URL)
+		c.Notify.Webhooks[i] = w
+	}
+	c.Target = c.Target.redacted()
+	c.Targets = make([]Target, len(cfg.Targets))
+	for i, t := range cfg.Targets {
+		c.Targets[i] = t.redacted()
+	}
+	return &c
+}
+
+// redactWebhookURL keeps only the scheme and host of a webhook URL, as the rest usually embeds a secret.
+func redactWebhookURL(url string) string {
+	if url == "" {
+		return ""
+	}
+	u, err := neturl.Parse(url)
+	if err != nil || u.Host == "" {
+		return RedactedValue
+	}
+	return u.Scheme + "://" + u.Host + "/" + RedactedValue
+}
+
+func (t Target) redacted() Target {
+	if t.BasicAuth.Password != "" {
+		t.BasicAuth.Password = RedactedValue
+	}
+	if t.Token != "" {
+		t.Token = RedactedValue
+	}
+	return t
+}
+
+// RenderConfig encodes the configuration as YAML, annotated with the field descriptions of DefaultConfigTemplate.
+// With sources, every value is also annotated with the file it came from.
+func RenderConfig(cfg *Config, sources ConfigSources) ([]byte, error) {
+	var doc yaml.Node
+	if err := doc.Encode(cfg); err != nil {
+		return nil, err
+	}
+
+	var tmpl yaml.Node
+	if err := yaml.Unmarshal([]byte(DefaultConfigTemplate), &tmpl); err != nil {
+		return nil, err
+	}
+	copyLineComments(&doc, tmpl.Content[0])
+	if sources != nil {
+		annotateSources(&doc, "", sources)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// copyLineComments copies the trailing comments of matching keys from the src mapping onto dst.
+func copyLineComments(dst, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(dst.Content); i += 2 {
+		for j := 0; j+1 < len(src.Content); j += 2 {
+			if dst.Content[i].Value != src.Content[j].Value {
+				continue
+			}
+			key, value := dst.Content[i], dst.Content[i+1]
+			key.LineComment = src.Content[j].LineComment
+			// The template writes lists and mappings in flow style ([] or {}), with the comment after the value.
+			// Encoded as a block, the value starts on the next line, so only a comment on the key stays on its line
+			if comment := src.Content[j+1].LineComment; (value.Kind == yaml.SequenceNode || value.Kind == yaml.MappingNode) && len(value.Content) > 0 {
+				if key.LineComment == "" {
+					key.LineComment = comment
+				}
+			} else {
+				value.LineComment = comment
+			}
+			copyLineComments(value, src.Content[j+1])
+		}
+	}
+}
+
+// targetList returns the configured targets, treating the single target section as a one-element list.
+func (cfg *Config) targetList() []Target {
+	if len(cfg.Targets) > 0 {
+		return cfg.Targets
+	}
+	return []Target{cfg.Target}
+}
+
+// timeoutFor returns the silence timeout of the target, falling back to the top-level timeout.
+func (cfg *Config) timeoutFor(t Target) time.Duration {
+	if t.Timeout > 0 {
+		return time.Duration(t.Timeout) * time.Second
+	}
+	return time.Duration(cfg.Timeout) * time.Second
+}
+
+// subscribeIDFor returns the id under which the target subscribes to SubscribeChannel.
+func subscribeIDFor(t Target) string {
+	if t.SubscribeID != "" {
+		return t.SubscribeID
+	}
+	return SubscribeID
+}
+
+// cooldownFor returns the cooldown of the target, falling back to the top-level cooldown.
+func (cfg *Config) cooldownFor(t Target) time.Duration {
+	if t.Cooldown > 0 {
+		return time.Duration(t.Cooldown) * time.Second
+	}
+	return time.Duration(cfg.Cooldown) * time.Second
+}
+
+// commandFor returns the recovery command of the target, falling back to the top-level command.
+func (cfg *Config) commandFor(t Target) string {
+	if t.Command != "" {
+		return t.Command
+	}
+	return cfg.Command
+}
+
+// checkCommandBinary looks up the command's executable the same way exec.Command does when it runs.
+// Shell commands are not checked, as the shell resolves them.
+func checkCommandBinary(cfg *Config, command string) error {
+	parts := strings.Fields(command)
+	if cfg.NotifyOnly || cfg.CommandShell || len(parts) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(parts[0]); err != nil {
+		return fmt.Errorf("command: %w", err)
+	}
+	return nil
+}
+
+// commandForCategory returns the recovery command for a failure category, falling back to commandFor.
+// An empty command configured for the category means no command runs.
+func (cfg *Config) commandForCategory(t Target, category string) string {
+	if command, ok := cfg.CommandsByCategory[category]; ok {
+		return command
+	}
+	return cfg.commandFor(t)
+}
+
+// sentryLevel returns the Sentry level of the events reporting a session that ended with category,
+// falling back to level when sentry.severity_map doesn't remap it.
+func (cfg *Config) sentryLevel(category string, level sentry.Level) sentry.Level {
+	if mapped, ok := cfg.Sentry.SeverityMap[category]; ok {
+		return sentry.Level(mapped)
+	}
+	return level
+}
+
+// Validate reports every problem found in the configuration, joined into one error.
+// Problems that don't prevent monitoring, such as a command binary that is missing for now, are only logged.
+func (cfg *Config) Validate() error {
+	var errs []error
+	checkBinary := func(path, command string) {
+		err := checkCommandBinary(cfg, command)
+		switch {
+		case err == nil:
+		case cfg.RequireCommand:
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		default:
+			writeLog(levelWarn, fmt.Sprintf("WARNING: %s: %v; the command will fail when it runs", path, err))
+		}
+	}
+	names := map[string]string{} // Name -> path of the target using it
+	for i, t := range cfg.targetList() {
+		path := "target"
+		if len(cfg.Targets) > 0 {
+			path = fmt.Sprintf("targets[%d]", i)
+		}
+
+		if url, err := getTargetURL(t); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		} else {
+			// The name is the metrics label, so two targets sharing one would mix their series
+			name := targetName(t, url)
+			if other, ok := names[name]; ok {
+				errs = append(errs, fmt.Errorf("%s.name: %q is already used by %s; set a distinct name for each target", path, name, other))
+			}
+			names[name] = path
+		}
+		if _, _, _, err := parseUnixSocketURL(t.URL); err != nil {
+			errs = append(errs, fmt.Errorf("%s.url: %w", path, err))
+		}
+		for j, u := range t.URLs {
+			if u.URL == "" {
+				errs = append(errs, fmt.Errorf("%s.urls[%d]: url must be specified", path, j))
+			}
+			if _, _, _, err := parseUnixSocketURL(u.URL); err != nil {
+				errs = append(errs, fmt.Errorf("%s.urls[%d]: %w", path, j, err))
+			}
+			if u.Weight < 0 {
+				errs = append(errs, fmt.Errorf("%s.urls[%d]: weight must not be negative", path, j))
+			}
+		}
+		if t.ParallelDial < 0 {
+			errs = append(errs, fmt.Errorf("%s.parallel_dial: must not be negative", path))
+		}
+		if t.SubscribeID != "" && strings.TrimSpace(t.SubscribeID) != t.SubscribeID {
+			errs = append(errs, fmt.Errorf("%s.subscribe_id: must not have leading or trailing whitespace", path))
+		}
+		switch t.Selection {
+		case "", SelectionOrdered, SelectionRandom, SelectionWeighted:
+		default:
+			errs = append(errs, fmt.Errorf("%s.selection: must be one of %s, %s or %s", path, SelectionOrdered, SelectionRandom, SelectionWeighted))
+		}
+		switch t.TokenPlacement {
+		case "", TokenPlacementQuery:
+		case TokenPlacementHeader:
+			if t.BasicAuth != (BasicAuth{}) {
+				errs = append(errs, fmt.Errorf("%s.token_placement: header cannot be combined with basic_auth, as both use the Authorization header", path))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s.token_placement: must be %s or %s", path, TokenPlacementQuery, TokenPlacementHeader))
+		}
+		if !cfg.NotifyOnly && len(strings.Fields(cfg.commandFor(t))) == 0 {
+			errs = append(errs, fmt.Errorf("%s: command must be specified (per target or at the top level), or notify_only set to run without one", path))
+		}
+		checkBinary(path, cfg.commandFor(t))
+		if t.Timeout < 0 || t.Cooldown < 0 {
+			errs = append(errs, fmt.Errorf("%s: timeout and cooldown must not be negative", path))
+		} else if cfg.timeoutFor(t) < MinTimeout {
+			errs = append(errs, fmt.Errorf("%s: timeout must be at least %s (per target or at the top level), or every session fails the moment it starts", path, MinTimeout))
+		}
+		for _, nt := range t.NoteTypes {
+			if !slices.Contains(NoteVisibilities, nt) {
+				errs = append(errs, fmt.Errorf("%s.note_types: unknown note type %q (must be one of %s)", path, nt, strings.Join(NoteVisibilities, ", ")))
+			}
+		}
+		if len(t.NoteTypes) > 0 && cfg.ParseDepth == ParseShallow {
+			errs = append(errs, fmt.Errorf("%s.note_types: cannot be used with parse_depth %s, which doesn't decode note visibilities", path, ParseShallow))
+		}
+		if len(t.NoteTypes) > 0 && cfg.LivenessMode == LivenessAnyMessage {
+			errs = append(errs, fmt.Errorf("%s.note_types: cannot be used with liveness_mode %s, where every frame counts as activity", path, LivenessAnyMessage))
+		}
+	}
+	for category, command := range cfg.CommandsByCategory {
+		switch {
+		case category == CategoryMaintenance:
+			errs = append(errs, fmt.Errorf("commands_by_category.%s: no command runs during maintenance, since restarting won't end it", category))
+		case category == CategoryRecovered || category == CategoryUnrecovered || !slices.Contains(NotifyCategories, category):
+			errs = append(errs, fmt.Errorf("commands_by_category: unknown failure category %q", category))
+		}
+		checkBinary("commands_by_category."+category, command)
+	}
+	for category, level := range cfg.Sentry.SeverityMap {
+		if category == CategoryRecovered || category == CategoryUnrecovered || !slices.Contains(NotifyCategories, category) {
+			errs = append(errs, fmt.Errorf("sentry.severity_map: unknown failure category %q", category))
+		}
+		if !slices.Contains(severityLevels, level) {
+			errs = append(errs, fmt.Errorf("sentry.severity_map.%s: unknown level %q (want one of %s)", category, level, strings.Join(severityLevels, ", ")))
+		}
+	}
+	if cfg.Dialer.LocalAddress != "" && net.ParseIP(cfg.Dialer.LocalAddress) == nil {
+		errs = append(errs, fmt.Errorf("dialer.local_address: %q is not a valid IP address", cfg.Dialer.LocalAddress))
+	}
+	switch cfg.Dialer.Network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		errs = append(errs, fmt.Errorf("dialer.network: must be one of tcp, tcp4 or tcp6"))
+	}
+	if cfg.Dialer.KeepAlive < -1 || cfg.Dialer.KeepAliveCount < 0 {
+		errs = append(errs, fmt.Errorf("dialer: keep_alive must be -1 or more and keep_alive_count must not be negative"))
+	}
+	if cfg.Dialer.ReadBufferSize < 0 || cfg.Dialer.WriteBufferSize < 0 || cfg.Dialer.HandshakeTimeout < 0 {
+		errs = append(errs, fmt.Errorf("dialer: buffer sizes and handshake_timeout must not be negative"))
+	}
+	if cfg.PingInterval < 0 || cfg.PongWait < 0 {
+		errs = append(errs, fmt.Errorf("ping_interval and pong_wait: must not be negative"))
+	}
+	if len(cfg.HeartbeatTypes) == 0 || slices.Contains(cfg.HeartbeatTypes, "") {
+		errs = append(errs, fmt.Errorf("heartbeat_types: must list at least one non-empty type"))
+	}
+	switch cfg.LivenessMode {
+	case LivenessHeartbeat, LivenessAnyMessage, LivenessNotesOnly:
+	default:
+		errs = append(errs, fmt.Errorf("liveness_mode: must be one of %s, %s or %s", LivenessHeartbeat, LivenessAnyMessage, LivenessNotesOnly))
+	}
+	if cfg.ParseDepth != ParseFull && cfg.ParseDepth != ParseShallow {
+		errs = append(errs, fmt.Errorf("parse_depth: must be %s or %s", ParseFull, ParseShallow))
+	}
+	if codes := cfg.CommandExitCodes; codes.Reconnect < 0 || codes.Reconnect > 255 || codes.ExtendCooldown < 0 || codes.ExtendCooldown > 255 {
+		errs = append(errs, fmt.Errorf("command_exit_codes: exit codes must be between 1 and 255 (0 = disabled)"))
+	} else if codes.Reconnect != 0 && codes.Reconnect == codes.ExtendCooldown {
+		errs = append(errs, fmt.Errorf("command_exit_codes: reconnect and extend_cooldown must differ"))
+	}
+	for _, status := range cfg.RetryableStatuses {
+		if status < 100 || status > 599 {
+			errs = append(errs, fmt.Errorf("retryable_statuses: %d is not an HTTP status code", status))
+		}
+	}
+	if cfg.VerifyAfterCommand < 0 {
+		errs = append(errs, fmt.Errorf("verify_after_command: must not be negative"))
+	}
+	if cfg.CommandExitCodes.ExtendedCooldown < 0 {
+		errs = append(errs, fmt.Errorf("command_exit_codes.extended_cooldown: must not be negative"))
+	}
+	if cfg.WriteTimeout < 0 {
+		errs = append(errs, fmt.Errorf("write_timeout: must not be negative"))
+	}
+	if cfg.Throughput.DropRatio < 0 || cfg.Throughput.DropRatio >= 1 {
+		errs = append(errs, fmt.Errorf("throughput.drop_ratio: must be at least 0 and less than 1"))
+	}
+	if cfg.Throughput.Window < 0 || cfg.Throughput.Baseline < cfg.Throughput.Window {
+		errs = append(errs, fmt.Errorf("throughput: window must not be negative and baseline must be at least window"))
+	}
+	if cfg.PrewarmInterval < 0 {
+		errs = append(errs, fmt.Errorf("prewarm_interval: must not be negative"))
+	}
+	if cfg.Reload.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("reload.timeout: must not be negative"))
+	}
+	if cfg.Maintenance.Cooldown < 0 {
+		errs = append(errs, fmt.Errorf("maintenance.cooldown: must not be negative"))
+	}
+	if cfg.StartupGrace < 0 {
+		errs = append(errs, fmt.Errorf("startup_grace: must not be negative"))
+	}
+	if cfg.StartupDelay < 0 || cfg.StartupJitter < 0 {
+		errs = append(errs, fmt.Errorf("startup_delay and startup_jitter: must not be negative"))
+	}
+	if cfg.MaxRuntime < 0 {
+		errs = append(errs, fmt.Errorf("max_runtime: must not be negative"))
+	}
+	if cfg.SubscribeAckTimeout < 0 {
+		errs = append(errs, fmt.Errorf("subscribe_ack_timeout: must not be negative"))
+	}
+	if cfg.PreflightAttempts < 0 || cfg.PreflightInterval < 0 {
+		errs = append(errs, fmt.Errorf("preflight_attempts and preflight_interval: must not be negative"))
+	}
+	if cfg.DNSCooldown < 0 {
+		errs = append(errs, fmt.Errorf("dns_cooldown: must not be negative"))
+	}
+	if cfg.ReconnectDelay < 0 {
+		errs = append(errs, fmt.Errorf("reconnect_delay: must not be negative"))
+	}
+	if cfg.RecoveryMinDuration < 0 {
+		errs = append(errs, fmt.Errorf("recovery_min_duration: must not be negative"))
+	}
+	if cfg.ReconnectAttempts < 0 {
+		errs = append(errs, fmt.Errorf("reconnect_attempts: must not be negative"))
+	}
+	if cfg.MalformedFrameRatio < 0 || cfg.MalformedFrameRatio > 1 {
+		errs = append(errs, fmt.Errorf("malformed_frame_ratio: must be between 0 and 1"))
+	}
+	if _, err := commandSysProcAttr(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseSchedule(cfg.Schedule); err != nil {
+		errs = append(errs, fmt.Errorf("schedule: %w", err))
+	}
+	if cfg.Quorum < 0 || cfg.Quorum >= 1 {
+		errs = append(errs, fmt.Errorf("quorum: must be at least 0 and less than 1"))
+	}
+	for name, status := range map[string]int{"health_ok_status": cfg.HTTP.HealthOKStatus, "health_fail_status": cfg.HTTP.HealthFailStatus} {
+		if status < 100 || status > 599 {
+			errs = append(errs, fmt.Errorf("http.%s: %d is not a valid HTTP status code", name, status))
+		}
+	}
+	if _, err := template.New("health_body").Parse(cfg.HTTP.HealthBody); err != nil {
+		errs = append(errs, fmt.Errorf("http.health_body: %w", err))
+	}
+	if cfg.Sentry.FlushTimeout < 0 {
+		errs = append(errs, fmt.Errorf("sentry.flush_timeout: must not be negative"))
+	}
+	if cfg.HTTP.EventsSize < 0 {
+		errs = append(errs, fmt.Errorf("http.events_size: must not be negative"))
+	}
+	errs = append(errs, validateNotify(cfg)...)
+	switch cfg.HTTP.HealthPolicy {
+	case HealthPolicyAll, HealthPolicyAny, HealthPolicyMajority:
+	default:
+		errs = append(errs, fmt.Errorf("http.health_policy: must be one of %s, %s or %s", HealthPolicyAll, HealthPolicyAny, HealthPolicyMajority))
+	}
+	switch cfg.Log.Level {
+	case "info", "debug":
+	default:
+		errs = append(errs, fmt.Errorf("log.level: must be either info or debug"))
+	}
+	switch cfg.Log.Format {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.format: must be either text or json"))
+	}
+	if cfg.Log.Timezone != "" {
+		if _, err := time.LoadLocation(cfg.Log.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("log.timezone: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func getTargetURL(t Target) (string, error) {
+	if t.URL != "" {
+		return t.URL, nil
+	}
+	if t.Domain != "" {
+		cleanDomain := strings.TrimSuffix(strings.TrimPrefix(t.Domain, "https://"), "/")
+		return fmt.Sprintf("wss://%s%s", cleanDomain, DefaultPath), nil
+	}
+	if len(t.URLs) > 0 && t.URLs[0].URL != "" {
+		return t.URLs[0].URL, nil
+	}
+	return "", fmt.Errorf("domain, url or urls must be specified in the configuration file")
+}
+
+// targetName returns the target's name, defaulting to the host of url (the socket path for ws+unix URLs).
+func targetName(t Target, url string) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	if socket, _, ok, _ := parseUnixSocketURL(url); ok {
+		return socket
+	}
+	if u, err := neturl.Parse(url); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return url
+}
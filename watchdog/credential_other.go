@@ -0,0 +1,16 @@
+//go:build !unix
+
+package watchdog
+
+import (
+	"errors"
+	"syscall"
+)
+
+// commandSysProcAttr reports an error when command_user or command_group is set, as switching users is only supported on Unix.
+func commandSysProcAttr(cfg *Config) (*syscall.SysProcAttr, error) {
+	if cfg.CommandUser != "" || cfg.CommandGroup != "" {
+		return nil, errors.New("command_user and command_group are only supported on Unix")
+	}
+	return nil, nil
+}
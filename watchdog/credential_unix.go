@@ -0,0 +1,56 @@
+//go:build unix
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// commandSysProcAttr resolves command_user and command_group into the credential the recovery command runs with.
+// It returns nil when neither is set, so the command inherits the watchdog's own user.
+// When only command_user is set, the user's primary group is used.
+func commandSysProcAttr(cfg *Config) (*syscall.SysProcAttr, error) {
+	if cfg.CommandUser == "" && cfg.CommandGroup == "" {
+		return nil, nil
+	}
+
+	cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if cfg.CommandUser != "" {
+		u, err := lookupUser(cfg.CommandUser)
+		if err != nil {
+			return nil, fmt.Errorf("command_user: %w", err)
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+	}
+	if cfg.CommandGroup != "" {
+		g, err := lookupGroup(cfg.CommandGroup)
+		if err != nil {
+			return nil, fmt.Errorf("command_group: %w", err)
+		}
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		cred.Gid = uint32(gid)
+	}
+	return &syscall.SysProcAttr{Credential: cred}, nil
+}
+
+// lookupUser accepts either a user name or a numeric UID.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+// lookupGroup accepts either a group name or a numeric GID.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
@@ -0,0 +1,37 @@
+package watchdog
+
+import "container/list"
+
+// MaxRecentNoteIDs bounds how many note IDs a session remembers for duplicate detection.
+const MaxRecentNoteIDs = 1000
+
+// recentIDs is a fixed-size set of recently seen IDs that evicts the least recently seen one when full.
+type recentIDs struct {
+	capacity int
+	order    *list.List // Front is the most recently seen ID
+	index    map[string]*list.Element
+}
+
+func newRecentIDs(capacity int) *recentIDs {
+	return &recentIDs{
+		capacity: capacity,
+		order:    list.New(),
+		index:    make(map[string]*list.Element, capacity),
+	}
+}
+
+// add records id and reports whether it was not already in the set.
+func (r *recentIDs) add(id string) bool {
+	if e, ok := r.index[id]; ok {
+		r.order.MoveToFront(e)
+		return false
+	}
+
+	r.index[id] = r.order.PushFront(id)
+	if r.order.Len() > r.capacity {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.index, oldest.Value.(string))
+	}
+	return true
+}
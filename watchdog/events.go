@@ -0,0 +1,73 @@
+package watchdog
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types kept in the /events history.
+const (
+	EventConnected    = "connected"
+	EventDisconnected = "disconnected"
+	EventFirstNote    = "first_note" // The first note of a session during an outage
+	EventRecovered    = "recovered"
+	EventCommand      = "command"
+	EventError        = "error"
+)
+
+// event is a significant occurrence on a target, as listed by /events.
+type event struct {
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target"`
+	Type     string    `json:"type"`
+	Category string    `json:"category,omitempty"` // Failure category of a disconnection
+	Message  string    `json:"message"`
+}
+
+// eventLog is a fixed-size ring buffer of the most recent events of all targets.
+type eventLog struct {
+	mu      sync.Mutex
+	entries []event
+	next    int // Index the next event is written to
+	full    bool
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{entries: make([]event, max(size, 1))}
+}
+
+func (l *eventLog) add(e event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the buffered events, oldest first.
+func (l *eventLog) list() []event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]event{}, l.entries[:l.next]...)
+	}
+	return append(append([]event{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+// recordEvent adds an event to the log of the fleet, if it keeps one.
+func (m *monitor) recordEvent(eventType, category, message string) {
+	if m.fleet.events == nil {
+		return // The self-test and -check serve no /events
+	}
+	m.fleet.events.add(event{
+		Time:     time.Now(),
+		Target:   m.name,
+		Type:     eventType,
+		Category: category,
+		Message:  message,
+	})
+}
@@ -0,0 +1,69 @@
+package watchdog
+
+const (
+	SelectionOrdered  = "ordered"
+	SelectionRandom   = "random"
+	SelectionWeighted = "weighted"
+)
+
+// nodeSelector chooses which failover node each session of a target connects to.
+// It is only used from the target's monitor goroutine.
+type nodeSelector struct {
+	nodes    []TargetURL
+	strategy string
+	next     int // Index of the next node for the ordered strategy
+}
+
+func newNodeSelector(t Target, url string) *nodeSelector {
+	nodes := t.URLs
+	if len(nodes) == 0 {
+		nodes = []TargetURL{{URL: url}}
+	}
+	return &nodeSelector{nodes: nodes, strategy: t.Selection}
+}
+
+// pick returns the URL to use for the next connection attempt.
+func (s *nodeSelector) pick() string {
+	switch s.strategy {
+	case SelectionRandom:
+		return s.nodes[random.IntN(len(s.nodes))].URL
+	case SelectionWeighted:
+		total := 0
+		for _, n := range s.nodes {
+			total += n.weight()
+		}
+		r := random.IntN(total)
+		for _, n := range s.nodes {
+			if r -= n.weight(); r < 0 {
+				return n.URL
+			}
+		}
+	}
+
+	url := s.nodes[s.next%len(s.nodes)].URL
+	s.next++
+	return url
+}
+
+// candidates returns up to n nodes to dial at once for parallel_dial: first, then the ones following it in the list.
+func (s *nodeSelector) candidates(first string, n int) []string {
+	start := 0
+	for i, node := range s.nodes {
+		if node.URL == first {
+			start = i
+			break
+		}
+	}
+	urls := []string{first}
+	for i := 1; i < min(n, len(s.nodes)); i++ {
+		urls = append(urls, s.nodes[(start+i)%len(s.nodes)].URL)
+	}
+	return urls
+}
+
+func (u TargetURL) weight() int {
+	if u.Weight <= 0 {
+		return 1
+	}
+	return u.Weight
+}
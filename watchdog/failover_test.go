@@ -0,0 +1,76 @@
+package watchdog
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// seedRandom replaces random with a fixed source for the test, and returns an identically seeded generator
+// to replay the draws the code under test makes.
+func seedRandom(t *testing.T) *rand.Rand {
+	prev := random
+	t.Cleanup(func() { random = prev })
+	SetRandomSource(rand.NewPCG(1, 2))
+	return rand.New(rand.NewPCG(1, 2))
+}
+
+func TestPickRandom(t *testing.T) {
+	replay := seedRandom(t)
+	s := &nodeSelector{
+		nodes:    []TargetURL{{URL: "wss://a"}, {URL: "wss://b"}, {URL: "wss://c"}},
+		strategy: SelectionRandom,
+	}
+
+	for i := range 20 {
+		want := s.nodes[replay.IntN(len(s.nodes))].URL
+		if got := s.pick(); got != want {
+			t.Fatalf("pick %d = %s, want %s", i, got, want)
+		}
+	}
+}
+
+// TestPickWeighted checks that each node gets weight draws out of the total, with a zero or negative weight counting as 1.
+func TestPickWeighted(t *testing.T) {
+	replay := seedRandom(t)
+	s := &nodeSelector{
+		nodes:    []TargetURL{{URL: "wss://a", Weight: 3}, {URL: "wss://b", Weight: 0}, {URL: "wss://c", Weight: -2}},
+		strategy: SelectionWeighted,
+	}
+	byDraw := []string{"wss://a", "wss://a", "wss://a", "wss://b", "wss://c"} // The node for each value of IntN(5)
+
+	counts := map[string]int{}
+	for i := range 500 {
+		want := byDraw[replay.IntN(len(byDraw))]
+		got := s.pick()
+		if got != want {
+			t.Fatalf("pick %d = %s, want %s", i, got, want)
+		}
+		counts[got]++
+	}
+	for _, n := range s.nodes {
+		if counts[n.URL] == 0 {
+			t.Errorf("%s was never picked", n.URL)
+		}
+	}
+	if counts["wss://a"] < 2*counts["wss://b"] {
+		t.Errorf("got %v, want wss://a picked about 3 times as often as wss://b", counts)
+	}
+}
+
+func TestStartupJitterIsReproducible(t *testing.T) {
+	cfg := &Config{StartupDelay: 2, StartupJitter: 5}
+
+	var delays []time.Duration
+	for range 2 {
+		seedRandom(t)
+		delay := startupDelay(cfg)
+		if delay < 2*time.Second || delay >= 7*time.Second {
+			t.Fatalf("delay %s is outside startup_delay + [0, startup_jitter)", delay)
+		}
+		delays = append(delays, delay)
+	}
+	if delays[0] != delays[1] {
+		t.Errorf("got %s and %s from the same seed, want the same delay", delays[0], delays[1])
+	}
+}
@@ -0,0 +1,57 @@
+package watchdog
+
+import (
+	"context"
+	"time"
+)
+
+// TargetInfo identifies the target a hook is called for.
+type TargetInfo struct {
+	Name        string // The target's name, which defaults to the host of its URL
+	Description string
+	URL         string // The first node when urls is used
+}
+
+// Disconnect describes a failed session that calls for recovery.
+type Disconnect struct {
+	Target   TargetInfo
+	Category string // Failure category, such as timeout or dns
+	Err      error  // Why the session ended
+}
+
+// Hooks are optional callbacks through which an embedding program can react to the lifecycle of each target
+// in Go instead of through a command. A hook runs on the monitor goroutine of its target, so it delays the
+// monitoring of that target until it returns; hooks of different targets may run concurrently.
+type Hooks struct {
+	// OnConnect is called when a session has subscribed to the timeline, before any message is read.
+	OnConnect func(target TargetInfo)
+
+	// OnMessage is called with every frame received, including ones that are not notes or can't be parsed.
+	// data is only valid until it returns.
+	OnMessage func(target TargetInfo, data []byte)
+
+	// OnDisconnect is called where the command would run: when a failed session is past reconnect_attempts
+	// and the quorum is reached. Maintenance and clean closes by the server don't call it. ctx is cancelled on shutdown,
+	// but not on a reload, which waits for OnDisconnect to return before replacing the monitors.
+	// When nil, the configured command runs; when set, it replaces the command along with notify_only,
+	// commands_by_category and command_async.
+	OnDisconnect func(ctx context.Context, d Disconnect)
+
+	// OnRecovery is called when a target that failed counts as recovered again, with how long it was down.
+	OnRecovery func(target TargetInfo, downtime time.Duration)
+}
+
+// info describes the monitor's target for the hooks.
+func (m *monitor) info() TargetInfo {
+	return TargetInfo{Name: m.name, Description: m.target.Description, URL: m.url}
+}
+
+// disconnected runs OnDisconnect, or the command when it is not set.
+// Like runCommand, it returns the command's exit code and whether it ran to completion.
+func (m *monitor) disconnected(ctx context.Context, category string, err error) (int, bool) {
+	if m.hooks.OnDisconnect == nil {
+		return m.runCommand(ctx, category)
+	}
+	m.hooks.OnDisconnect(ctx, Disconnect{Target: m.info(), Category: category, Err: err})
+	return 0, false
+}
@@ -0,0 +1,146 @@
+package watchdog
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSources maps the dotted key path of every value set by a configuration file (e.g. dialer.network)
+// to the file that set it last.
+type ConfigSources map[string]string
+
+// LoadLayeredConfig reads every file in order and deep-merges them before decoding: mappings are merged
+// key by key, while scalars and lists from a later file replace the earlier value as a whole.
+func LoadLayeredConfig(paths []string) (*Config, ConfigSources, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode}
+	sources := ConfigSources{}
+	for _, path := range paths {
+		root, err := readConfigNode(path)
+		if err != nil {
+			if len(paths) > 1 {
+				err = fmt.Errorf("%s: %w", path, err)
+			}
+			return nil, nil, err
+		}
+		if root != nil {
+			mergeConfigNode(merged, root, "", path, sources)
+		}
+	}
+
+	if err := checkConfigNode(merged); err != nil {
+		return nil, nil, err
+	}
+	var cfg Config
+	if err := merged.Decode(&cfg); err != nil {
+		return nil, nil, err
+	}
+	cfg.applyDefaults()
+	return &cfg, sources, nil
+}
+
+// readConfigNode parses one configuration source, returning nil for an empty document.
+func readConfigNode(path string) (*yaml.Node, error) {
+	data, err := readConfigSource(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+		return nil, nil
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("the configuration must be a YAML mapping")
+	}
+	return doc.Content[0], nil
+}
+
+// mergeConfigNode merges the src mapping into dst, recording in sources which file set each value.
+func mergeConfigNode(dst, src *yaml.Node, prefix, file string, sources ConfigSources) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+
+		j := mappingIndex(dst, key.Value)
+		if j >= 0 && (value.Kind != yaml.MappingNode || dst.Content[j+1].Kind != yaml.MappingNode) {
+			sources.forget(path) // The earlier value is replaced as a whole
+		}
+		if value.Kind != yaml.MappingNode {
+			sources[path] = file
+			if j < 0 {
+				dst.Content = append(dst.Content, key, value)
+			} else {
+				dst.Content[j+1] = value
+			}
+			continue
+		}
+
+		if j < 0 {
+			dst.Content = append(dst.Content, key, &yaml.Node{Kind: yaml.MappingNode})
+			j = len(dst.Content) - 2
+		} else if dst.Content[j+1].Kind != yaml.MappingNode {
+			dst.Content[j+1] = &yaml.Node{Kind: yaml.MappingNode}
+		}
+		mergeConfigNode(dst.Content[j+1], value, path, file, sources)
+	}
+}
+
+// mappingIndex returns the index of key's key node in the mapping node m, or -1.
+func mappingIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// forget removes path and everything below it.
+func (s ConfigSources) forget(path string) {
+	for p := range s {
+		if p == path || strings.HasPrefix(p, path+".") {
+			delete(s, p)
+		}
+	}
+}
+
+// annotateSources appends the contributing file, or "default", to the line comment of every value in the
+// rendered configuration mapping m.
+func annotateSources(m *yaml.Node, prefix string, sources ConfigSources) {
+	if m.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, value := m.Content[i], m.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+		if value.Kind == yaml.MappingNode && len(value.Content) > 0 {
+			annotateSources(value, path, sources)
+			continue
+		}
+
+		source, ok := sources[path]
+		if !ok {
+			source = "default"
+		}
+		// Block sequences print their comment after the key; empty ones are written in flow style
+		node := value
+		if value.Kind == yaml.SequenceNode && len(value.Content) > 0 {
+			node = key
+		}
+		if node.LineComment == "" {
+			node.LineComment = "from " + source
+		} else {
+			node.LineComment += " (from " + source + ")"
+		}
+	}
+}
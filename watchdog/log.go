@@ -0,0 +1,278 @@
+package watchdog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Lets log.timezone work on hosts without a zoneinfo database (e.g. Windows)
+
+	"github.com/getsentry/sentry-go"
+	"golang.org/x/term"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+	levelFatal
+)
+
+// levelColors holds the ANSI escape sequences used for each level when colored output is enabled.
+var levelColors = map[logLevel]string{
+	levelDebug: "\x1b[90m",   // Gray
+	levelWarn:  "\x1b[33m",   // Yellow
+	levelError: "\x1b[31m",   // Red
+	levelFatal: "\x1b[1;31m", // Bold red
+}
+
+// sentryLevels maps log levels to the Sentry level used for breadcrumbs.
+var sentryLevels = map[logLevel]sentry.Level{
+	levelDebug: sentry.LevelDebug,
+	levelInfo:  sentry.LevelInfo,
+	levelWarn:  sentry.LevelWarning,
+	levelError: sentry.LevelError,
+	levelFatal: sentry.LevelFatal,
+}
+
+// severityLevels are the levels sentry.severity_map accepts.
+var severityLevels = []string{
+	string(sentry.LevelDebug), string(sentry.LevelInfo), string(sentry.LevelWarning), string(sentry.LevelError), string(sentry.LevelFatal),
+}
+
+// levelNames are the values of the level property of JSON log lines.
+var levelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+	levelFatal: "fatal",
+}
+
+// logField is a structured value attached to a log line:
+// a property of the object in log.format json, and key=value after the message in text.
+type logField struct {
+	Key   string
+	Value any
+}
+
+// jsonLogging writes every log line as a JSON object; set from log.format.
+var jsonLogging bool
+
+// logLocation and logLayout render the time property of JSON log lines; set from log.timezone and log.time_format.
+var (
+	logLocation = time.Local
+	logLayout   = time.RFC3339
+)
+
+// colorOutput enables colored log lines; set by SetupLogger when logging to a terminal.
+var colorOutput bool
+
+// useBreadcrumbs records routine log lines as Sentry breadcrumbs instead of standalone events; set from sentry.use_breadcrumbs.
+var useBreadcrumbs bool
+
+// sentryFlushTimeout bounds every wait for queued Sentry events; set from sentry.flush_timeout.
+var sentryFlushTimeout = DefaultSentryFlushTimeout
+
+// FlushSentry waits up to sentry.flush_timeout for queued events to be sent and reports whether they were.
+func FlushSentry() bool {
+	return sentry.Flush(sentryFlushTimeout)
+}
+
+// debugLogging enables logDebugf output; set from log.level.
+var debugLogging bool
+
+// timestampWriter prefixes every log line with a timestamp in the configured layout and time zone.
+// The log package writes each line with a single Write call.
+type timestampWriter struct {
+	w      io.Writer
+	loc    *time.Location
+	layout string
+}
+
+func (tw *timestampWriter) Write(p []byte) (int, error) {
+	line := append([]byte(time.Now().In(tw.loc).Format(tw.layout)+" "), p...)
+	if _, err := tw.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetupLogger applies the log section of the configuration to the standard logger.
+// When neither timezone nor time_format is set, the standard library's local-time prefix is kept.
+func SetupLogger(cfg *Config) {
+	debugLogging = cfg.Log.Level == "debug"
+	jsonLogging = cfg.Log.Format == "json"
+	colorOutput = !jsonLogging && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stderr.Fd()))
+
+	if jsonLogging {
+		// The time is a property of each object, so the logger adds no prefix
+		if l, err := time.LoadLocation(cfg.Log.Timezone); err == nil && cfg.Log.Timezone != "" {
+			logLocation = l
+		}
+		if cfg.Log.TimeFormat != "" {
+			logLayout = logTimeLayout(cfg.Log.TimeFormat)
+		}
+		log.SetFlags(0)
+		return
+	}
+	if cfg.Log.Timezone == "" && cfg.Log.TimeFormat == "" {
+		return
+	}
+	loc := time.Local
+	if l, err := time.LoadLocation(cfg.Log.Timezone); err == nil { // Invalid names are reported by Validate
+		loc = l
+	}
+	layout := DefaultLogTimeFormat
+	if cfg.Log.TimeFormat != "" {
+		layout = logTimeLayout(cfg.Log.TimeFormat)
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(&timestampWriter{w: os.Stderr, loc: loc, layout: layout})
+}
+
+// logTimeLayout resolves the named formats accepted by log.time_format; anything else is used as a Go time layout.
+func logTimeLayout(format string) string {
+	switch strings.ToLower(format) {
+	case "rfc3339":
+		return time.RFC3339
+	case "rfc3339nano":
+		return time.RFC3339Nano
+	}
+	return format
+}
+
+// writeLog writes a line to the standard logger, colored by level when enabled.
+func writeLog(level logLevel, msg string, fields ...logField) {
+	if jsonLogging {
+		log.Println(jsonLogLine(level, msg, fields))
+		return
+	}
+	for _, f := range fields {
+		msg += " " + f.Key + "=" + textFieldValue(f.Value)
+	}
+	if color, ok := levelColors[level]; ok && colorOutput {
+		msg = color + msg + "\x1b[0m"
+	}
+	log.Println(msg)
+}
+
+// jsonLogLine renders a log line as a single-line JSON object with the time, level and message before the fields.
+func jsonLogLine(level logLevel, msg string, fields []logField) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	add := func(key string, value any) {
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Quote(key) + ":")
+		b.Write(v)
+	}
+	add("time", time.Now().In(logLocation).Format(logLayout))
+	add("level", levelNames[level])
+	add("msg", msg)
+	for _, f := range fields {
+		add(f.Key, f.Value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// textFieldValue formats a field value for a text log line, quoting strings that would otherwise be ambiguous.
+func textFieldValue(value any) string {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Sprint(value)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logDebugf writes diagnostic details to the log only; they are never sent to Sentry.
+func logDebugf(format string, v ...interface{}) {
+	if !debugLogging {
+		return
+	}
+	writeLog(levelDebug, "DEBUG: "+fmt.Sprintf(format, v...))
+}
+
+// logDebugFields is logDebugf for a message that is already formatted, with fields.
+func logDebugFields(msg string, fields ...logField) {
+	if debugLogging {
+		writeLog(levelDebug, "DEBUG: "+msg, fields...)
+	}
+}
+
+func logPrintf(format string, v ...interface{}) {
+	logMessage(sentry.CurrentHub(), levelInfo, fmt.Sprintf(format, v...))
+}
+
+func logMessage(hub *sentry.Hub, level logLevel, msg string, fields ...logField) {
+	writeLog(level, msg, fields...)
+
+	if useBreadcrumbs {
+		// Attached to the next error event captured on this hub
+		hub.AddBreadcrumb(&sentry.Breadcrumb{
+			Category: "log",
+			Level:    sentryLevels[level],
+			Message:  msg,
+		}, nil)
+		return
+	}
+
+	// CHANGED: Use CaptureMessage instead of Breadcrumb
+	hub.CaptureMessage(msg)
+}
+
+// setTargetTags tags Sentry events with the name and URL of the target and the channel they relate to.
+func setTargetTags(scope *sentry.Scope, name, url string) {
+	scope.SetTag("target", name)
+	scope.SetTag("target_url", url)
+	scope.SetTag("channel", SubscribeChannel)
+}
+
+// setRemoteTag tags Sentry events of a session with the address it connected to, if it got that far.
+func setRemoteTag(scope *sentry.Scope, remote string) {
+	if remote != "" {
+		scope.SetTag("remote_addr", remote)
+	}
+}
+
+// Printf logs an informational message like the watchdog's own, sending it to Sentry unless sentry.use_breadcrumbs is set.
+func Printf(format string, v ...interface{}) {
+	logPrintf(format, v...)
+}
+
+// Errorf is Printf at error level.
+func Errorf(format string, v ...interface{}) {
+	logMessage(sentry.CurrentHub(), levelError, fmt.Sprintf(format, v...))
+}
+
+// Fatalf logs a fatal error, sends it to Sentry and exits the process.
+func Fatalf(format string, v ...interface{}) {
+	logFatalf(format, v...)
+}
+
+func logFatalf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	writeLog(levelFatal, "FATAL: "+msg)
+
+	sentry.CaptureMessage("FATAL: " + msg)
+	FlushSentry()
+
+	os.Exit(1)
+}
@@ -0,0 +1,95 @@
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// MaxMaintenanceBodySize bounds how much of a failed handshake response is searched for maintenance patterns.
+const MaxMaintenanceBodySize = 4096
+
+// maintenanceError reports that the server announced maintenance, which restarting it won't fix.
+type maintenanceError struct {
+	reason string
+	err    error
+}
+
+func (e *maintenanceError) Error() string {
+	return fmt.Sprintf("server is in maintenance (%s): %v", e.reason, e.err)
+}
+
+func (e *maintenanceError) Unwrap() error {
+	return e.err
+}
+
+func isMaintenance(err error) bool {
+	var maintErr *maintenanceError
+	return errors.As(err, &maintErr)
+}
+
+// matchMaintenance returns the first configured pattern found in text, case-insensitively.
+func (cfg *Config) matchMaintenance(text string) (string, bool) {
+	lower := strings.ToLower(text)
+	for _, p := range cfg.Maintenance.Patterns {
+		if p != "" && strings.Contains(lower, strings.ToLower(p)) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// maintenanceFromClose classifies a read error by its close code and reason.
+func (cfg *Config) maintenanceFromClose(err error) error {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return nil
+	}
+	if slices.Contains(cfg.Maintenance.CloseCodes, closeErr.Code) {
+		return &maintenanceError{reason: fmt.Sprintf("close code %d", closeErr.Code), err: err}
+	}
+	if p, ok := cfg.matchMaintenance(closeErr.Text); ok {
+		return &maintenanceError{reason: fmt.Sprintf("close reason matches %q", p), err: err}
+	}
+	return nil
+}
+
+// maintenanceFromHandshake classifies a failed dial by the body of the server's HTTP response, if any.
+func (cfg *Config) maintenanceFromHandshake(resp *http.Response, err error) error {
+	if resp == nil || resp.Body == nil {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, MaxMaintenanceBodySize))
+	if p, ok := cfg.matchMaintenance(string(body)); ok {
+		return &maintenanceError{reason: fmt.Sprintf("handshake response (%s) matches %q", resp.Status, p), err: err}
+	}
+	return nil
+}
+
+// maintenanceFromFrame classifies an error frame from the server. Other frames are never checked: notes could
+// contain anything, and broadcasts such as announcementCreated reach every connection, announcing maintenance
+// that is yet to come.
+func (cfg *Config) maintenanceFromFrame(msg *streamMessage, data []byte) error {
+	if msg == nil || msg.Type != "error" {
+		return nil
+	}
+	if p, ok := cfg.matchMaintenance(string(data)); ok {
+		return &maintenanceError{reason: fmt.Sprintf("frame matches %q", p), err: errors.New("maintenance announced by the server")}
+	}
+	return nil
+}
+
+// setMaintenance records whether the target is in maintenance and reports whether that changed.
+func (m *monitor) setMaintenance(on bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := m.maintenance != on
+	m.maintenance = on
+	return changed
+}
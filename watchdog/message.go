@@ -0,0 +1,101 @@
+package watchdog
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// streamMessage is the envelope of a frame sent by the Misskey Streaming API.
+// Channel events look like {"type":"channel","body":{"id":"1","type":"note","body":{...}}}.
+type streamMessage struct {
+	Type string `json:"type"`
+	Body struct {
+		ID   string          `json:"id"`
+		Type string          `json:"type"`
+		Body json.RawMessage `json:"body"`
+	} `json:"body"`
+}
+
+// KnownMessageTypes are the values of the type label of watchdog_messages_total besides heartbeat_types,
+// malformed and other, so that an unusual server can't blow up the label cardinality.
+var KnownMessageTypes = []string{"note", "connected", "noteUpdated", "announcementCreated", "emojiAdded", "emojiUpdated", "emojiDeleted"}
+
+const (
+	LivenessHeartbeat  = "heartbeat"   // heartbeat_types decide what counts as activity
+	LivenessAnyMessage = "any_message" // Every received frame does
+	LivenessNotesOnly  = "notes_only"  // Only note events do
+
+	ParseFull    = "full"    // Every note is decoded for duplicate detection and note_types
+	ParseShallow = "shallow" // Only the envelope is decoded
+)
+
+// NoteVisibilities lists the note visibilities accepted by target.note_types.
+var NoteVisibilities = []string{"public", "home", "followers", "specified"}
+
+// streamNote holds the fields of a note that the watchdog looks at.
+type streamNote struct {
+	ID         string `json:"id"`
+	Visibility string `json:"visibility"`
+}
+
+// note returns the note carried by a channel "note" event.
+func (msg *streamMessage) note() (*streamNote, bool) {
+	if !msg.isNote() {
+		return nil, false
+	}
+	var note streamNote
+	if err := json.Unmarshal(msg.Body.Body, &note); err != nil {
+		return nil, false
+	}
+	return &note, true
+}
+
+// fromSubscription reports whether msg belongs to the subscription with id. Only channel events are addressed
+// to a subscription; other frames concern the whole connection. As a session holds a single subscription,
+// a channel event without an id can only be meant for it.
+func (msg *streamMessage) fromSubscription(id string) bool {
+	return msg.Type != "channel" || msg.Body.ID == "" || msg.Body.ID == id
+}
+
+// isNote reports whether msg is a channel "note" event, without decoding the note.
+func (msg *streamMessage) isNote() bool {
+	return msg.Type == "channel" && msg.Body.Type == "note"
+}
+
+// hasType reports whether the frame's type, or for a channel frame the type of its event (e.g. note), is one of types.
+func (msg *streamMessage) hasType(types []string) bool {
+	if slices.Contains(types, msg.Type) {
+		return true
+	}
+	return msg.Type == "channel" && slices.Contains(types, msg.Body.Type)
+}
+
+// typeLabel returns the type label of the frame for watchdog_messages_total; msg is nil for malformed frames.
+func (msg *streamMessage) typeLabel(heartbeatTypes []string) string {
+	if msg == nil {
+		return "malformed"
+	}
+	t := msg.Type
+	if t == "channel" {
+		t = msg.Body.Type
+	}
+	if slices.Contains(KnownMessageTypes, t) || slices.Contains(heartbeatTypes, t) {
+		return t
+	}
+	return "other"
+}
+
+// subscribePayload returns the request subscribing to SubscribeChannel under id.
+// Its pong parameter asks the server for a "connected" acknowledgment.
+func subscribePayload(id string) []byte {
+	quoted, _ := json.Marshal(id)
+	return []byte(`{"type":"connect","body":{"channel":"` + SubscribeChannel + `","id":` + string(quoted) + `,"params":{"withRenotes":true,"minimize":true},"pong":true}}`)
+}
+
+func parseStreamMessage(data []byte) (*streamMessage, error) {
+	var msg streamMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
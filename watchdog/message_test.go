@@ -0,0 +1,31 @@
+package watchdog
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// benchmarkFrame is a note event of about 1.5 KB, the size of a typical note with its user on globalTimeline.
+var benchmarkFrame = fmt.Appendf(nil, `{"type":"channel","body":{"id":"1","type":"note","body":{"id":"abc","createdAt":"2026-01-01T00:00:00Z","userId":"u1","user":{"id":"u1","name":"n","username":"x","avatarUrl":"https://example.com/a.png","emojis":{},"badgeRoles":[]},"text":%q,"visibility":"public","reactions":{"a":1,"b":2},"fileIds":[],"files":[],"tags":["a","b"]}}}`,
+	strings.Repeat("hello world ", 100))
+
+func benchmarkParse(b *testing.B, depth string) {
+	m := &monitor{cfg: &Config{ParseDepth: depth}}
+	b.SetBytes(int64(len(benchmarkFrame)))
+	b.ReportAllocs()
+	for b.Loop() {
+		msg, err := parseStreamMessage(benchmarkFrame)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, ok := m.parseNote(msg); !ok {
+			b.Fatal("not parsed as a note")
+		}
+	}
+}
+
+// BenchmarkParseShallow and BenchmarkParseFull compare the parse_depth settings on the path of every frame.
+func BenchmarkParseShallow(b *testing.B) { benchmarkParse(b, ParseShallow) }
+
+func BenchmarkParseFull(b *testing.B) { benchmarkParse(b, ParseFull) }
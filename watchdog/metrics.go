@@ -0,0 +1,90 @@
+package watchdog
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricBytesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_bytes_received_total",
+		Help: "Total size of the WebSocket frames received from the streaming API, in bytes.",
+	}, []string{"target"})
+
+	metricCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "watchdog_command_duration_seconds",
+		Help:    "Time taken by the recovery command, whether it succeeded or failed.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12), // 0.1s to ~3.4m
+	}, []string{"target"})
+
+	metricConnectDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "watchdog_connect_duration_seconds",
+		Help:    "Time taken by the WebSocket dial including the handshake, until success or failure.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12), // 10ms to ~20s
+	}, []string{"target", "result"})
+
+	metricLastCommandExitCode = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_last_command_exit_code",
+		Help: "Exit code of the most recent recovery command (-1 if it could not be started or was killed).",
+	}, []string{"target"})
+
+	metricLastCommandTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_last_command_timestamp_seconds",
+		Help: "Unix time at which the most recent recovery command started.",
+	}, []string{"target"})
+
+	metricMalformedFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_malformed_frames_total",
+		Help: "Total number of received frames that could not be parsed as JSON.",
+	}, []string{"target"})
+
+	metricDuplicateNotes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_duplicate_notes_total",
+		Help: "Total number of notes received again within a session; these do not count as activity.",
+	}, []string{"target"})
+
+	metricStartups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_startups_total",
+		Help: "Incremented once per target when its subscription is first confirmed after the watchdog starts; reconnections and reloads are not counted.",
+	}, []string{"target"})
+
+	metricTargetUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_target_up",
+		Help: "1 while the target is connected and its timeline shows activity, 0 otherwise (connecting, silent or failed).",
+	}, []string{"target"})
+
+	metricConsecutiveFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_consecutive_failures",
+		Help: "Consecutive failed sessions of the target; reset by a healthy session. Suited for alerts such as > 5.",
+	}, []string{"target"})
+
+	metricNextReconnect = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_next_reconnect_timestamp_seconds",
+		Help: "Unix time of the next scheduled reconnection attempt, or 0 while connecting or connected.",
+	}, []string{"target"})
+
+	metricCooldown = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_cooldown_seconds",
+		Help: "Length of the wait before the next reconnection attempt the target is in, or 0 while connecting or connected.",
+	}, []string{"target"})
+
+	metricCooldownTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_cooldown_seconds_total",
+		Help: "Total time spent waiting to reconnect after a session ended, counted when each wait ends.",
+	}, []string{"target"})
+
+	metricMessageGap = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_message_gap_seconds",
+		Help: "Maximum and running average time between consecutive note events in the current session.",
+	}, []string{"target", "stat"})
+
+	metricMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_messages_total",
+		Help: "Total number of received frames by type: the event type of channel frames (e.g. note), the frame type otherwise (e.g. connected), malformed or other.",
+	}, []string{"target", "type"})
+
+	metricDeadlineExtensions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_deadline_extensions_total",
+		Help: "Total number of times a liveness deadline was pushed back: the read deadline by activity, or the half-open check by a pong.",
+	}, []string{"target", "source"})
+)
@@ -0,0 +1,1312 @@
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	MinFramesForMalformedRatio = 10      // Frames to read before the malformed ratio is enforced
+	MaxOutputAttachmentSize    = 1 << 20 // Bytes of command output kept with sentry.attach_output
+	SubscribeChannel           = "globalTimeline"
+	SubscribeID                = "1" // Default of target.subscribe_id
+)
+
+// newDialer returns the dialer of every connection to the targets. With warm, it dials the addresses
+// prewarmed for a host first and keeps TLS sessions to resume.
+func newDialer(cfg *Config, warm *warmCache) *websocket.Dialer {
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		ReadBufferSize:   cfg.Dialer.ReadBufferSize,
+		WriteBufferSize:  cfg.Dialer.WriteBufferSize,
+		HandshakeTimeout: time.Duration(cfg.Dialer.HandshakeTimeout) * time.Second,
+		Subprotocols:     cfg.Dialer.Subprotocols,
+	}
+
+	// Catches dead peers at the TCP layer too, complementing ping/pong
+	netDialer := &net.Dialer{}
+	switch keepAlive := cfg.Dialer.KeepAlive; {
+	case keepAlive < 0:
+		netDialer.KeepAlive = -1
+	case keepAlive > 0 || cfg.Dialer.KeepAliveCount > 0:
+		netDialer.KeepAliveConfig = net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     time.Duration(keepAlive) * time.Second, // 0 keeps the Go default
+			Interval: time.Duration(keepAlive) * time.Second,
+			Count:    cfg.Dialer.KeepAliveCount,
+		}
+	}
+	if cfg.Dialer.LocalAddress != "" {
+		netDialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(cfg.Dialer.LocalAddress)}
+	}
+	network := cfg.Dialer.Network
+	dialer.NetDialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		logDebugf("Dialing %s (network: %s)", addr, network)
+		if warm != nil {
+			if c, ok := warm.dial(ctx, netDialer, network, addr); ok {
+				return c, nil
+			}
+		}
+		return netDialer.DialContext(ctx, network, addr)
+	}
+	if warm != nil {
+		dialer.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	}
+	return dialer
+}
+
+// dial opens the WebSocket connection to a node, through its socket for ws+unix URLs.
+func (m *monitor) dial(ctx context.Context, node string) (*websocket.Conn, *http.Response, error) {
+	dialer, dialURL := m.dialer, node
+	if socket, requestURI, ok, _ := parseUnixSocketURL(node); ok { // Already checked by Validate
+		dialer, dialURL = unixSocketDialer(m.dialer, socket), "ws://localhost"+requestURI
+	}
+	return dialer.DialContext(ctx, m.dialURL(dialURL), m.header)
+}
+
+// dialFirst dials every node at once and returns the first connection to succeed, closing the others
+// once their dials have been cancelled. When all fail, it returns the error and response of the first node.
+func (m *monitor) dialFirst(ctx context.Context, nodes []string) (string, *websocket.Conn, *http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		index int
+		c     *websocket.Conn
+		resp  *http.Response
+		err   error
+	}
+	results := make(chan dialResult, len(nodes))
+	for i, node := range nodes {
+		go func() {
+			c, resp, err := m.dial(ctx, node)
+			results <- dialResult{i, c, resp, err}
+		}()
+	}
+
+	winner := -1
+	var c *websocket.Conn
+	var primary dialResult
+	for range nodes {
+		r := <-results
+		switch {
+		case r.err == nil && winner < 0:
+			winner, c = r.index, r.c
+			cancel() // Abort the slower dials
+		case r.err == nil:
+			_ = r.c.Close() // Connected before noticing that it lost
+		case r.index == 0:
+			primary = r
+		case winner < 0:
+			m.logDebugf("Dialing %s failed: %v", nodes[r.index], r.err)
+		}
+	}
+	if winner < 0 {
+		return nodes[0], nil, primary.resp, primary.err
+	}
+	return nodes[winner], c, nil, nil
+}
+
+// dialURL adds the access token to a node URL when it is sent as a query parameter.
+// The result contains the token, so log the node URL instead.
+func (m *monitor) dialURL(node string) string {
+	if m.target.Token == "" || m.target.TokenPlacement == TokenPlacementHeader {
+		return node
+	}
+	u, err := url.Parse(node)
+	if err != nil {
+		return node // Dialing reports the invalid URL
+	}
+	q := u.Query()
+	q.Set("i", m.target.Token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// handshakeHeader builds the extra HTTP headers sent when connecting to t.
+func handshakeHeader(t Target) http.Header {
+	header := http.Header{}
+	if t.BasicAuth.Username != "" || t.BasicAuth.Password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(t.BasicAuth.Username + ":" + t.BasicAuth.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+	if t.Token != "" && t.TokenPlacement == TokenPlacementHeader {
+		header.Set("Authorization", "Bearer "+t.Token)
+	}
+	return header
+}
+
+// fleet tracks the up/down state of every monitored target centrally.
+type fleet struct {
+	monitors []*monitor
+	warm     *warmCache // nil unless prewarm_interval is set
+	startups *startups  // Outlives the fleet across reloads; nil for the self-test and -check, which announce nothing
+	events   *eventLog  // Listed by /events; outlives the fleet like startups, and nil where they are
+}
+
+// startups remembers the targets whose startup has been announced, so that a reload, which replaces the fleet,
+// doesn't announce them again.
+type startups struct {
+	mu        sync.Mutex
+	announced map[string]bool // By target name
+}
+
+func newStartups() *startups {
+	return &startups{announced: map[string]bool{}}
+}
+
+// first reports whether this is the first call for target, always false for a nil receiver.
+func (s *startups) first(target string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.announced[target] {
+		return false
+	}
+	s.announced[target] = true
+	return true
+}
+
+// newFleet creates a monitor for every target in cfg, calling hooks and recording into events, and logs the
+// resulting setup. Startups already announced in started are not announced again.
+func newFleet(cfg *Config, hooks Hooks, started *startups, events *eventLog) *fleet {
+	f := &fleet{startups: started, events: events}
+	if cfg.PrewarmInterval > 0 {
+		f.warm = newWarmCache(time.Duration(cfg.PrewarmInterval) * time.Second)
+	}
+	dialer := newDialer(cfg, f.warm)
+	targets := cfg.targetList()
+
+	var targetNames []string
+	for _, t := range targets {
+		m := newMonitor(cfg, t, dialer, f, len(targets) > 1)
+		m.hooks = hooks
+		f.monitors = append(f.monitors, m)
+		targetNames = append(targetNames, fmt.Sprintf("%s (%s)", m.name, m.url))
+	}
+	if len(f.monitors) == 1 {
+		// Events logged outside the monitor (e.g. logFatalf) can only be about this one target
+		sentry.ConfigureScope(func(scope *sentry.Scope) {
+			setTargetTags(scope, f.monitors[0].name, f.monitors[0].url)
+		})
+	}
+
+	if len(f.monitors) == 1 {
+		logPrintf("Configuration Loaded. Target: %s, Timeout: %s, Cooldown: %s", f.monitors[0].url, f.monitors[0].timeout, f.monitors[0].cooldown)
+	} else {
+		logPrintf("Configuration Loaded. Targets: %s", strings.Join(targetNames, ", "))
+		for _, m := range f.monitors {
+			m.logPrintf("Timeout: %s, Cooldown: %s", m.timeout, m.cooldown)
+		}
+	}
+	if cfg.NotifyOnly {
+		logPrintf("Notify-only mode: failures will be reported, but the command will never be executed.")
+	}
+	return f
+}
+
+// start runs every monitor until ctx is cancelled. Commands and OnDisconnect get shutdown, which outlives ctx
+// across reloads. The returned function waits for the monitors to stop.
+func (f *fleet) start(ctx, drain, shutdown context.Context) func() {
+	var wg sync.WaitGroup
+	for _, m := range f.monitors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.run(ctx, drain, shutdown)
+		}()
+	}
+	if f.warm != nil {
+		wg.Go(func() { f.prewarm(ctx) })
+	}
+	return wg.Wait
+}
+
+// countDown returns how many targets have failed and not come back up yet.
+// Targets that have not finished their first session are not counted.
+func (f *fleet) countDown() int {
+	n := 0
+	for _, m := range f.monitors {
+		if m.isDown() {
+			n++
+		}
+	}
+	return n
+}
+
+// quorumReached reports whether more than the given fraction of targets is down.
+func (f *fleet) quorumReached(quorum float64) bool {
+	return float64(f.countDown()) > quorum*float64(len(f.monitors))
+}
+
+// monitor watches a single target and runs its recovery command when the session fails.
+type monitor struct {
+	cfg            *Config
+	dialer         *websocket.Dialer
+	fleet          *fleet
+	hub            *sentry.Hub // Scoped to this target so tags don't bleed across monitors
+	target         Target
+	name           string // Identifies the target in logs, metrics labels, notifications and Sentry
+	url            string // The first node when urls is used
+	subscribeID    string // Channel events carrying another id belong to someone else's subscription
+	nodes          *nodeSelector
+	header         http.Header          // Sent with the handshake; may hold credentials, so never log it
+	schedule       cron.Schedule        // nil when monitoring is always on
+	procAttr       *syscall.SysProcAttr // Credential for the command; nil keeps the watchdog's own user
+	timeout        time.Duration
+	cooldown       time.Duration
+	reconnectDelay time.Duration
+	prefix         string // Prepended to log lines; empty when only one target is monitored
+	hooks          Hooks  // Set by newFleet; none for the self-test and -check
+
+	commandRunning atomic.Bool // Guards against overlapping executions of the recovery command
+	bytesReceived  atomic.Int64
+	beatDeadline   atomic.Int64 // Unix nanoseconds by which the run loop must come around again; 0 = no bound
+	sessionID      atomic.Pointer[string]
+
+	mu           sync.Mutex
+	up           bool
+	since        time.Time // When up last changed
+	lastError    string
+	failingSince time.Time // Start of the current failure streak; zero while healthy
+	lastCommand  *commandStatus
+	scheduledOff bool // Idling outside the monitoring schedule
+	maintenance  bool // The last session ended because the server announced maintenance
+	receiving    bool // Activity has arrived on the current session
+
+	failureStreak int       // Consecutive failed sessions, as counted by run
+	nextAttempt   time.Time // When the next connection attempt is due; zero while connecting or connected
+}
+
+func newMonitor(cfg *Config, t Target, dialer *websocket.Dialer, f *fleet, multi bool) *monitor {
+	url, _ := getTargetURL(t) // Already checked by Validate
+	schedule, _ := parseSchedule(cfg.Schedule)
+	procAttr, _ := commandSysProcAttr(cfg)
+
+	m := &monitor{
+		cfg:            cfg,
+		dialer:         dialer,
+		fleet:          f,
+		target:         t,
+		name:           targetName(t, url),
+		url:            url,
+		subscribeID:    subscribeIDFor(t),
+		nodes:          newNodeSelector(t, url),
+		header:         handshakeHeader(t),
+		schedule:       schedule,
+		procAttr:       procAttr,
+		timeout:        cfg.timeoutFor(t),
+		cooldown:       cfg.cooldownFor(t),
+		reconnectDelay: max(time.Duration(cfg.ReconnectDelay)*time.Second, MinCooldown),
+		since:          time.Now(),
+	}
+	if multi {
+		m.prefix = fmt.Sprintf("[%s] ", m.name)
+	}
+
+	m.hub = sentry.CurrentHub().Clone()
+	m.hub.ConfigureScope(func(scope *sentry.Scope) {
+		setTargetTags(scope, m.name, url)
+	})
+	metricTargetUp.WithLabelValues(m.name).Set(0) // Down until proven otherwise
+	return m
+}
+
+func (m *monitor) logPrintf(format string, v ...interface{}) {
+	logMessage(m.hub, levelInfo, m.prefix+fmt.Sprintf(format, v...), m.logFields()...)
+}
+
+func (m *monitor) logWarnf(format string, v ...interface{}) {
+	logMessage(m.hub, levelWarn, m.prefix+fmt.Sprintf(format, v...), m.logFields()...)
+}
+
+func (m *monitor) logErrorf(format string, v ...interface{}) {
+	m.recordEvent(EventError, "", fmt.Sprintf(format, v...))
+	logMessage(m.hub, levelError, m.prefix+fmt.Sprintf(format, v...), m.logFields()...)
+}
+
+func (m *monitor) logDebugf(format string, v ...interface{}) {
+	if !debugLogging {
+		return // Before formatting, as this runs for every frame
+	}
+	logDebugFields(m.prefix+fmt.Sprintf(format, v...), m.logFields()...)
+}
+
+// logFields returns the fields added to every log line of the target: the id of its latest session, if any.
+func (m *monitor) logFields(fields ...logField) []logField {
+	if id := m.sessionID.Load(); id != nil {
+		fields = append(fields, logField{"session_id", *id})
+	}
+	return fields
+}
+
+// startSession gives the session that is starting a new id, which the log lines and Sentry events of the target
+// carry until the next one starts, so that everything about one connection attempt can be grouped.
+func (m *monitor) startSession() {
+	id := newUUID()
+	m.sessionID.Store(&id)
+	m.hub.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTag("session_id", id)
+	})
+}
+
+// setState records whether the target is currently up, along with the error that brought it down.
+func (m *monitor) setState(up bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.up != up {
+		m.up = up
+		m.since = time.Now()
+	}
+	if !up {
+		m.receiving = false
+	}
+	if up {
+		m.maintenance = false
+	}
+	if err != nil {
+		m.lastError = err.Error()
+	}
+	m.updateTargetUp()
+}
+
+// updateTargetUp sets watchdog_target_up to whether the target is connected and its timeline alive.
+// m.mu must be held.
+func (m *monitor) updateTargetUp() {
+	up := 0.0
+	if m.up && m.receiving {
+		up = 1
+	}
+	metricTargetUp.WithLabelValues(m.name).Set(up)
+}
+
+func (m *monitor) isDown() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return !m.up && m.lastError != "" && !m.scheduledOff && !m.maintenance
+}
+
+// setBackoff publishes the failure streak and when the next connection attempt is due (zero for now).
+func (m *monitor) setBackoff(streak int, next time.Time) {
+	m.mu.Lock()
+	m.failureStreak, m.nextAttempt = streak, next
+	m.mu.Unlock()
+
+	metricConsecutiveFailures.WithLabelValues(m.name).Set(float64(streak))
+	if next.IsZero() {
+		metricNextReconnect.WithLabelValues(m.name).Set(0)
+	} else {
+		metricNextReconnect.WithLabelValues(m.name).Set(float64(next.Unix()))
+	}
+}
+
+// sleepCooldown waits d before the next reconnection attempt, accounting for it in the cooldown metrics.
+// It returns false if ctx is done first.
+func (m *monitor) sleepCooldown(ctx context.Context, d time.Duration) bool {
+	gauge, total := metricCooldown.WithLabelValues(m.name), metricCooldownTotal.WithLabelValues(m.name)
+	gauge.Set(d.Seconds())
+	start := time.Now()
+	defer func() {
+		gauge.Set(0)
+		total.Add(time.Since(start).Seconds())
+	}()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// logBackoff reports, at shutdown, a failure streak that was still in progress.
+func (m *monitor) logBackoff() {
+	m.mu.Lock()
+	streak, next := m.failureStreak, m.nextAttempt
+	m.mu.Unlock()
+
+	switch {
+	case streak == 0:
+	case next.IsZero():
+		m.logPrintf("Stopped during a failure streak of %d sessions.", streak)
+	default:
+		m.logPrintf("Stopped during a failure streak of %d sessions; the next attempt was due at %s.", streak, next.Format(time.RFC3339))
+	}
+}
+
+// markFailing records the start of a failure streak; later failures keep the original time.
+func (m *monitor) markFailing() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failingSince.IsZero() {
+		m.failingSince = time.Now()
+	}
+}
+
+// reportFirstNote announces the first note of a session during an outage, which comes before the target
+// counts as recovered when recovery_min_duration is set.
+func (m *monitor) reportFirstNote(afterConnect time.Duration) {
+	m.mu.Lock()
+	failingSince := m.failingSince
+	m.mu.Unlock()
+
+	if failingSince.IsZero() {
+		return
+	}
+	outage := time.Since(failingSince)
+	writeLog(levelInfo, fmt.Sprintf("%sFirst note received after %s of outage (%s after connecting); not recovered until the session lasts %ds.",
+		m.prefix, outage.Round(time.Second), afterConnect.Round(time.Millisecond), m.cfg.RecoveryMinDuration), m.logFields()...)
+	m.recordEvent(EventFirstNote, "", fmt.Sprintf("first note after %s of outage", outage.Round(time.Second)))
+
+	m.hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelInfo)
+		scope.SetExtra("outage_seconds", outage.Seconds())
+		m.hub.CaptureMessage(fmt.Sprintf("%sfirst note received after outage", m.prefix))
+	})
+}
+
+// markRecovered ends the failure streak, if any, and sends the resolved notification.
+// It is called on a note, so it doubles as the first-note-after-outage signal when recovery_min_duration is 0.
+func (m *monitor) markRecovered() {
+	m.mu.Lock()
+	failingSince := m.failingSince
+	m.failingSince = time.Time{}
+	m.mu.Unlock()
+	m.setBackoff(0, time.Time{}) // run resets its count once the session ends
+
+	if failingSince.IsZero() {
+		return
+	}
+	downtime := time.Since(failingSince)
+	writeLog(levelInfo, fmt.Sprintf("%sRecovered after %s of downtime.", m.prefix, downtime.Round(time.Second)), m.logFields()...)
+	m.recordEvent(EventRecovered, "", fmt.Sprintf("recovered after %s of downtime", downtime.Round(time.Second)))
+
+	m.hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelInfo)
+		scope.SetExtra("downtime_seconds", downtime.Seconds())
+		m.hub.CaptureMessage(fmt.Sprintf("%starget recovered after %s", m.prefix, downtime.Round(time.Second)))
+	})
+	m.notify(CategoryRecovered, fmt.Sprintf("%starget recovered after %s of downtime", m.prefix, downtime.Round(time.Second)))
+	if m.hooks.OnRecovery != nil {
+		m.hooks.OnRecovery(m.info(), downtime)
+	}
+}
+
+// run monitors the target until ctx is cancelled. Once drain, which ctx cancels too, is done,
+// run lets the current session end on its own and returns instead of reconnecting.
+// The command runs with shutdown instead of ctx, so that a reload doesn't kill it halfway through a restart.
+func (m *monitor) run(ctx, drain, shutdown context.Context) {
+	failures := 0 // Consecutive sessions that ended without recovering
+	for {
+		if !m.onSchedule() && !m.waitForSchedule(drain) {
+			return
+		}
+
+		// A. Start Monitoring
+		m.setBackoff(failures, time.Time{})
+		sessionCtx, cancel := m.scheduleContext(ctx)
+		stats, err := m.startMonitoringSession(sessionCtx)
+		windowClosed := sessionCtx.Err() != nil
+		cancel()
+		if ctx.Err() != nil {
+			m.setState(false, err)
+			return
+		}
+		if drain.Err() != nil {
+			m.setState(false, err)
+			m.logPrintf("Session ended (%v). Handing over to the reloaded configuration.", err)
+			return
+		}
+		if windowClosed {
+			m.setState(false, nil)
+			if m.onSchedule() {
+				m.logDebugf("Reconnecting to re-evaluate a long monitoring window.")
+			} else {
+				m.logPrintf("Monitoring window closed. Disconnected.")
+			}
+			failures = 0
+			continue
+		}
+		m.setState(false, err)
+
+		if stats.recovered {
+			failures = 0
+		}
+
+		// Restarting won't bring back an instance that is down for maintenance, so wait it out instead
+		if isMaintenance(err) {
+			entered := m.setMaintenance(true)
+			m.reportSession(stats, err, levelWarn)
+			if entered {
+				m.notify(CategoryMaintenance, fmt.Sprintf("%starget is in maintenance: %v", m.prefix, err))
+			}
+			cooldown := max(m.reconnectWait(stats, time.Duration(m.cfg.Maintenance.Cooldown)*time.Second), MinCooldown)
+			m.logWarnf(">>> Server is in maintenance. Skipping command and waiting %s before reconnecting...", cooldown)
+			m.expectBeat(cooldown)
+			m.setBackoff(failures, time.Now().Add(cooldown))
+
+			if !m.sleepCooldown(drain, cooldown) {
+				return
+			}
+			continue
+		}
+
+		// The server closed a healthy session on purpose (e.g. a restart behind a load balancer): reconnect quickly
+		if failures == 0 && isCleanClose(err) {
+			m.reportSession(stats, err, levelWarn)
+			delay := max(m.reconnectWait(stats, m.reconnectDelay), MinCooldown)
+			m.logPrintf(">>> Connection closed by the server. Reconnecting in %s...", delay)
+			m.expectBeat(delay)
+			m.setBackoff(failures, time.Now().Add(delay))
+
+			if !m.sleepCooldown(drain, delay) {
+				return
+			}
+			continue
+		}
+
+		// A reverse proxy answering for an instance that is restarting (e.g. 502): running the command again
+		// would only restart it once more, so retry quietly without counting towards the failure streak
+		if slices.Contains(m.cfg.RetryableStatuses, stats.rejectedStatus) {
+			m.reportSession(stats, err, levelWarn)
+			delay := max(m.reconnectWait(stats, m.reconnectDelay), MinCooldown)
+			m.logPrintf(">>> Handshake rejected with status %d (retryable_statuses). Skipping command and reconnecting in %s...", stats.rejectedStatus, delay)
+			m.expectBeat(delay)
+			m.setBackoff(failures, time.Now().Add(delay))
+
+			if !m.sleepCooldown(drain, delay) {
+				return
+			}
+			continue
+		}
+		failures++
+		m.markFailing()
+		m.setBackoff(failures, time.Time{}) // For the session summary
+
+		// B. Report Crash to Sentry (Error Level)
+		m.reportSession(stats, err, levelError)
+		exitCode, commandRan := 0, false
+		if failures <= m.cfg.ReconnectAttempts {
+			m.logWarnf("Reconnect attempt %d/%d before running the command.", failures, m.cfg.ReconnectAttempts)
+		} else if m.fleet.quorumReached(m.cfg.Quorum) {
+			m.hub.WithScope(func(scope *sentry.Scope) {
+				scope.SetLevel(m.cfg.sentryLevel(stats.category, sentry.LevelError))
+				scope.SetTag("failure_category", stats.category)
+				setRemoteTag(scope, stats.remote)
+				m.hub.CaptureException(err)
+			})
+			m.notify(stats.category, fmt.Sprintf("%starget failed (%s): %v", m.prefix, stats.category, err))
+
+			// C. Execute command
+			m.expectBeat(0) // Commands have no time limit
+			exitCode, commandRan = m.disconnected(shutdown, stats.category, err)
+			if commandRan && m.cfg.VerifyAfterCommand > 0 {
+				exitCode = m.verifyCommand(ctx, shutdown, stats.category, exitCode)
+			}
+		} else {
+			m.logWarnf("Quorum not reached (%d/%d targets down). Skipping command.", m.fleet.countDown(), len(m.fleet.monitors))
+		}
+
+		// D. Cooldown
+		cooldown := m.cooldown
+		if commandRan {
+			cooldown = m.commandCooldown(exitCode, cooldown)
+		} else if stats.category == CategoryDNS {
+			// Only between quiet retries: after a command, the instance gets the regular cooldown to come back
+			cooldown = time.Duration(m.cfg.DNSCooldown) * time.Second
+		}
+		cooldown = max(m.reconnectWait(stats, cooldown), MinCooldown)
+		m.logPrintf(">>> Waiting %s before reconnecting...", cooldown)
+		m.expectBeat(cooldown)
+		m.setBackoff(failures, time.Now().Add(cooldown))
+		// Flush in the background so a slow Sentry doesn't delay the reconnect; logFatalf still flushes synchronously
+		go FlushSentry()
+
+		if !m.sleepCooldown(drain, cooldown) {
+			return
+		}
+
+		m.logPrintf(">>> Cooldown finished. Retrying connection...")
+	}
+}
+
+// sessionStats summarizes a single monitoring session for the report emitted when it ends.
+type sessionStats struct {
+	node     string
+	remote   string // Address the connection ended up at, e.g. the node picked by DNS round-robin; empty if the dial failed
+	start    time.Time
+	duration time.Duration
+	connect  time.Duration // Time taken by the dial, also set when it failed
+	messages int
+	bytes    int64
+	notes    int
+	peakGap  time.Duration // Longest wait for a note, including the final one that never came
+	avgGap   time.Duration // Running average of the gaps between consecutive notes
+
+	recovered bool   // Saw activity after lasting at least recovery_min_duration
+	category  string // Why the session ended, one of the Category constants
+
+	reconnectHint time.Duration // Reconnect interval suggested by the server when the session ended; 0 if none
+
+	rejectedStatus int // HTTP status with which the server rejected the handshake; 0 otherwise
+}
+
+// observeGap folds the wait for a note into the session stats and publishes them.
+func (m *monitor) observeGap(stats *sessionStats, gap time.Duration) {
+	stats.notes++
+	stats.peakGap = max(stats.peakGap, gap)
+	stats.avgGap += (gap - stats.avgGap) / time.Duration(stats.notes)
+
+	metricMessageGap.WithLabelValues(m.name, "max").Set(stats.peakGap.Seconds())
+	metricMessageGap.WithLabelValues(m.name, "avg").Set(stats.avgGap.Seconds())
+}
+
+// isCleanClose reports whether the session ended with a close frame the server sent deliberately.
+func isCleanClose(err error) bool {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+	return slices.Contains([]int{websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseServiceRestart}, closeErr.Code)
+}
+
+// reportSession logs one summary line for a finished session and sends it to Sentry with the stats attached,
+// at the level sentry.severity_map sets for its category if any.
+func (m *monitor) reportSession(stats sessionStats, err error, level logLevel) {
+	m.mu.Lock()
+	consecutive := m.failureStreak
+	m.mu.Unlock()
+	summary := fmt.Sprintf("Monitor session ended with error: %v (category=%s consecutive_failures=%d node=%s remote_addr=%s connect=%s duration=%s messages=%d bytes=%d total_bytes=%d notes=%d peak_gap=%s avg_gap=%s)",
+		err, stats.category, consecutive, stats.node, stats.remote, stats.connect.Round(time.Millisecond), stats.duration.Round(time.Millisecond), stats.messages, stats.bytes, m.bytesReceived.Load(), stats.notes,
+		stats.peakGap.Round(time.Millisecond), stats.avgGap.Round(time.Millisecond))
+	writeLog(level, m.prefix+summary, m.logFields()...)
+	m.recordEvent(EventDisconnected, stats.category, fmt.Sprint(err))
+
+	m.hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(m.cfg.sentryLevel(stats.category, sentryLevels[level]))
+		scope.SetTag("failure_category", stats.category)
+		setRemoteTag(scope, stats.remote)
+		scope.SetExtras(map[string]interface{}{
+			"session_node":             stats.node,
+			"consecutive_failures":     consecutive,
+			"session_connect_seconds":  stats.connect.Seconds(),
+			"session_duration_seconds": stats.duration.Seconds(),
+			"session_messages":         stats.messages,
+			"session_bytes":            stats.bytes,
+			"session_notes":            stats.notes,
+			"session_peak_gap_seconds": stats.peakGap.Seconds(),
+			"session_avg_gap_seconds":  stats.avgGap.Seconds(),
+			"session_end_reason":       fmt.Sprint(err),
+		})
+		m.hub.CaptureMessage(m.prefix + summary)
+	})
+}
+
+// startMonitoringSession runs one connection until it fails and returns what was observed during it.
+func (m *monitor) startMonitoringSession(ctx context.Context) (stats sessionStats, err error) {
+	m.startSession()
+	url := m.nodes.pick()
+	stats.node = url
+	stats.start = time.Now()
+	var lastNote time.Time // Zero until the subscription is in place
+	defer func() {
+		now := time.Now()
+		stats.duration = now.Sub(stats.start)
+		if !lastNote.IsZero() {
+			stats.peakGap = max(stats.peakGap, now.Sub(lastNote))
+		}
+	}()
+
+	candidates := m.nodes.candidates(url, m.target.ParallelDial)
+	switch {
+	case len(candidates) > 1:
+		m.logPrintf("Connecting to Misskey Streaming API (%s at once)...", strings.Join(candidates, ", "))
+	case len(m.nodes.nodes) > 1:
+		m.logPrintf("Connecting to Misskey Streaming API (%s)...", url)
+	default:
+		m.logPrintf("Connecting to Misskey Streaming API...")
+	}
+
+	m.expectBeat(m.dialer.HandshakeTimeout) // Bounds the whole dial
+	dialStart := time.Now()
+	var c *websocket.Conn
+	var resp *http.Response
+	if len(candidates) > 1 {
+		url, c, resp, err = m.dialFirst(ctx, candidates)
+		stats.node = url
+	} else {
+		c, resp, err = m.dial(ctx, url)
+	}
+	stats.connect = time.Since(dialStart)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metricConnectDuration.WithLabelValues(m.name, result).Observe(stats.connect.Seconds())
+	if err != nil {
+		err = fmt.Errorf("connection failed: %w", err)
+		stats.reconnectHint, _ = retryAfterHint(resp)
+		if resp != nil {
+			stats.rejectedStatus = resp.StatusCode
+		}
+		if maintErr := m.cfg.maintenanceFromHandshake(resp, err); maintErr != nil {
+			stats.category = CategoryMaintenance
+			return stats, maintErr
+		}
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			stats.category = CategoryDNS
+			return stats, fmt.Errorf("connection failed: DNS lookup of %s failed: %w", dnsErr.Name, dnsErr)
+		}
+		stats.category = CategoryConnection
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			stats.category = CategoryAuth
+		}
+		return stats, err
+	}
+	defer c.Close()
+	stats.remote = c.NetConn().RemoteAddr().String()
+	m.logDebugf("Connected to %s (%s).", stats.remote, stats.node)
+
+	if want := m.dialer.Subprotocols; len(want) > 0 && !slices.Contains(want, c.Subprotocol()) {
+		m.logWarnf("Server did not accept any of the requested subprotocols %v (negotiated: %q)", want, c.Subprotocol())
+	}
+	if tlsConn, ok := c.NetConn().(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		resumed := ""
+		if state.DidResume {
+			resumed = " (resumed session)"
+		}
+		m.logDebugf("Negotiated %s with %s%s.", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), resumed)
+	}
+
+	// Every goroutine of the session stops with sessionCtx and is waited for before returning,
+	// so none pile up over thousands of reconnects. Async commands belong to the monitor, not the session.
+	sessionCtx, endSession := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer endSession()
+
+	// ReadMessage can't take a context, so unblock it by closing the connection when the session ends
+	wg.Go(func() {
+		<-sessionCtx.Done()
+		_ = c.Close()
+	})
+
+	w := newSessionWriter(c, m.cfg)
+	if err := w.write(websocket.TextMessage, subscribePayload(m.subscribeID)); err != nil {
+		stats.category = CategoryConnection
+		return stats, fmt.Errorf("subscribe request failed: %w", err)
+	}
+
+	pongWait := time.Duration(m.cfg.PongWait) * time.Second
+
+	var pongMissed atomic.Bool
+	if m.cfg.PingInterval > 0 {
+		interval := time.Duration(m.cfg.PingInterval) * time.Second
+		pongExtensions := metricDeadlineExtensions.WithLabelValues(m.name, "pong")
+		startPinger(sessionCtx, &wg, w, interval, pongWait, &pongMissed, func() {
+			pongExtensions.Inc()
+			m.logDebugf("Pong received: half-open check extended by %s.", interval+pongWait)
+		})
+	}
+
+	m.logPrintf("Monitoring started (Listening for messages)...")
+	m.recordEvent(EventConnected, "", "subscribed to "+SubscribeChannel+" on "+url)
+	m.setState(true, nil)
+	if m.hooks.OnConnect != nil {
+		m.hooks.OnConnect(m.info())
+	}
+	lastNote = time.Now()
+	metricMessageGap.WithLabelValues(m.name, "max").Set(0)
+	metricMessageGap.WithLabelValues(m.name, "avg").Set(0)
+
+	timeoutDuration := m.timeout
+	bytesCounter := metricBytesReceived.WithLabelValues(m.name)
+	malformedCounter := metricMalformedFrames.WithLabelValues(m.name)
+	duplicateCounter := metricDuplicateNotes.WithLabelValues(m.name)
+	activityExtensions := metricDeadlineExtensions.WithLabelValues(m.name, "activity")
+	seen := newRecentIDs(MaxRecentNoteIDs)
+	throughput := newThroughputBaseline(m.cfg, time.Now())
+	var malformed int
+	receiving, noteSeen, announced := false, false, false
+	ackTimeout := time.Duration(m.cfg.SubscribeAckTimeout) * time.Second
+	acked, ackDeadline := ackTimeout == 0, time.Now().Add(ackTimeout)
+
+	// The first message may take a little longer to arrive right after subscribing
+	deadline := time.Now().Add(timeoutDuration + time.Duration(m.cfg.StartupGrace)*time.Second)
+	for {
+		// Until the subscription is acknowledged, only the ack deadline applies, so a slow acknowledgment
+		// isn't mistaken for a quiet timeline
+		readDeadline := deadline
+		if !acked {
+			readDeadline = ackDeadline
+		}
+		m.expectBeat(time.Until(readDeadline))
+		if err := c.SetReadDeadline(readDeadline); err != nil {
+			stats.category = CategoryConnection
+			return stats, fmt.Errorf("failed to set read deadline: %w", err)
+		}
+
+		_, data, err := c.ReadMessage()
+		stats.bytes += int64(len(data))
+		m.bytesReceived.Add(int64(len(data)))
+		bytesCounter.Add(float64(len(data)))
+		if err != nil {
+			stats.reconnectHint, _ = reconnectHint(err)
+			if ctx.Err() != nil {
+				stats.category = CategoryCancelled
+				return stats, fmt.Errorf("session cancelled: %w", ctx.Err())
+			}
+			if pongMissed.Load() {
+				stats.category = CategoryHalfOpen
+				return stats, fmt.Errorf("no pong received within %s (half-open connection): %w", pongWait, err)
+			}
+			if writeErr := w.failed(); writeErr != nil {
+				// The writer closed the connection, so the read error only echoes the write failure
+				stats.category = CategoryConnection
+				return stats, writeErr
+			}
+			if maintErr := m.cfg.maintenanceFromClose(err); maintErr != nil {
+				stats.category = CategoryMaintenance
+				return stats, maintErr
+			}
+			stats.category = CategoryDisconnect
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if !acked {
+					// An open connection that never confirms the subscription looks like a quiet timeline otherwise
+					stats.category = CategorySubscribe
+					return stats, fmt.Errorf("subscription not acknowledged within %s (wrong channel or missing permission?): %w", ackTimeout, err)
+				}
+				stats.category = CategoryTimeout
+			} else if isCleanClose(err) {
+				stats.category = CategoryClosed
+			}
+			return stats, fmt.Errorf("read timeout or disconnection: %w", err)
+		}
+
+		stats.messages++
+		if m.hooks.OnMessage != nil {
+			m.hooks.OnMessage(m.info(), data)
+		}
+		msg, err := parseStreamMessage(data)
+		frameType := msg.typeLabel(m.cfg.HeartbeatTypes)
+		metricMessages.WithLabelValues(m.name, frameType).Inc()
+		ours := msg != nil && msg.fromSubscription(m.subscribeID)
+		if msg != nil && !ours {
+			m.logDebugf("Ignoring %s event for subscription %q (ours is %q).", msg.Body.Type, msg.Body.ID, m.subscribeID)
+		}
+		subscribed := msg != nil && msg.Body.ID == m.subscribeID && (msg.Type == "connected" || msg.Type == "channel")
+		if !acked && subscribed {
+			acked = true
+			m.logDebugf("Subscription acknowledged (%s frame).", msg.Type)
+			if ackDeadline.After(deadline) {
+				deadline = time.Now().Add(timeoutDuration)
+			}
+		}
+		// Broadcasts such as announcementCreated arrive without a subscription, so only its ack or events confirm one
+		if !announced && subscribed {
+			announced = true
+			if m.fleet.startups.first(m.name) {
+				m.reportStartup(url, msg.Type)
+			}
+		}
+		if err != nil {
+			malformed++
+			malformedCounter.Inc()
+			m.logDebugf("Ignoring malformed frame (%d of %d this session): %v", malformed, stats.messages, err)
+
+			if stats.messages >= MinFramesForMalformedRatio && float64(malformed)/float64(stats.messages) > m.cfg.MalformedFrameRatio {
+				stats.category = CategoryMalformed
+				return stats, fmt.Errorf("too many malformed frames: %d of %d", malformed, stats.messages)
+			}
+		}
+
+		if maintErr := m.cfg.maintenanceFromFrame(msg, data); maintErr != nil {
+			stats.category = CategoryMaintenance
+			return stats, maintErr
+		}
+
+		// A replayed note proves nothing about the timeline, so only unseen ones count
+		duplicate, isNote := false, false
+		if ours {
+			if note, ok := m.parseNote(msg); ok {
+				if note.ID != "" && !seen.add(note.ID) {
+					duplicate = true
+					duplicateCounter.Inc()
+					m.logDebugf("Ignoring duplicate note %s", note.ID)
+				} else {
+					isNote = true
+					now := time.Now()
+					m.observeGap(&stats, now.Sub(lastNote))
+					lastNote = now
+					if throughput != nil {
+						if drop := throughput.observe(now); drop != nil {
+							stats.category = CategoryThroughput
+							return stats, drop
+						}
+					}
+				}
+			}
+		}
+
+		activity := ours && !duplicate && m.isActivity(msg)
+		if m.cfg.LivenessMode == LivenessAnyMessage {
+			activity = true // Any traffic, even a replayed note, proves the socket is alive
+		}
+		if activity {
+			deadline = time.Now().Add(timeoutDuration)
+			activityExtensions.Inc()
+			m.logDebugf("Read deadline extended by %s (%s frame).", timeoutDuration, frameType)
+			if !receiving {
+				receiving = true
+				m.setReceiving(true)
+			}
+			// Connecting proves nothing until a note arrives, and a session that drops right after that
+			// is no recovery either, so it has to last a while first
+			if isNote && !stats.recovered && time.Since(stats.start) >= time.Duration(m.cfg.RecoveryMinDuration)*time.Second {
+				stats.recovered = true
+				m.markRecovered()
+			} else if isNote && !noteSeen {
+				m.reportFirstNote(time.Since(stats.start))
+			}
+			noteSeen = noteSeen || isNote
+		}
+	}
+}
+
+// reportStartup announces that the watchdog is online, so a deploy can be confirmed from metrics or Sentry.
+// frameType is the frame that confirmed the subscription: connected or channel.
+func (m *monitor) reportStartup(node, frameType string) {
+	metricStartups.WithLabelValues(m.name).Inc()
+	writeLog(levelInfo, fmt.Sprintf("%sWatchdog online: subscribed to %s on %s (%s frame).", m.prefix, SubscribeChannel, node, frameType), m.logFields()...)
+
+	m.hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelInfo)
+		scope.SetExtra("node", node)
+		m.hub.CaptureMessage(fmt.Sprintf("%swatchdog started: subscribed to %s", m.prefix, SubscribeChannel))
+	})
+}
+
+// isActivity reports whether a message of the subscription proves the timeline is alive: one of heartbeat_types,
+// a note with liveness_mode notes_only, or with note_types, a note of one of those visibilities.
+// msg is nil for frames that could not be parsed.
+func (m *monitor) isActivity(msg *streamMessage) bool {
+	if msg == nil {
+		return false
+	}
+	if len(m.target.NoteTypes) == 0 && m.cfg.LivenessMode != LivenessNotesOnly {
+		return msg.hasType(m.cfg.HeartbeatTypes)
+	}
+	note, ok := m.parseNote(msg)
+	return ok && (len(m.target.NoteTypes) == 0 || slices.Contains(m.target.NoteTypes, note.Visibility))
+}
+
+// parseNote returns the note carried by msg. With parse_depth shallow, the note is not decoded and
+// comes back empty, so it has no id to detect duplicates with.
+func (m *monitor) parseNote(msg *streamMessage) (*streamNote, bool) {
+	if m.cfg.ParseDepth == ParseShallow {
+		return &streamNote{}, msg.isNote()
+	}
+	return msg.note()
+}
+
+// commandCooldown applies command_exit_codes to the cooldown that follows a command run, letting the command
+// decide that no cooldown or a longer one is needed.
+func (m *monitor) commandCooldown(exitCode int, cooldown time.Duration) time.Duration {
+	codes := m.cfg.CommandExitCodes
+	switch {
+	case codes.Reconnect != 0 && exitCode == codes.Reconnect:
+		m.logPrintf("Command exited with %d (command_exit_codes.reconnect): skipping the cooldown.", exitCode)
+		return 0
+	case codes.ExtendCooldown != 0 && exitCode == codes.ExtendCooldown:
+		extended := 2 * cooldown
+		if codes.ExtendedCooldown > 0 {
+			extended = time.Duration(codes.ExtendedCooldown) * time.Second
+		}
+		m.logPrintf("Command exited with %d (command_exit_codes.extend_cooldown): extending the cooldown to %s.", exitCode, extended)
+		return extended
+	}
+	return cooldown
+}
+
+// startPinger pings the server every interval and closes the connection when a ping is not answered
+// within pongWait, so a dead-but-open socket is noticed before the read timeout fires.
+// The pinger runs in wg and stops when ctx is done. onPong is called for every pong, which pushes the check back.
+func startPinger(ctx context.Context, wg *sync.WaitGroup, w *sessionWriter, interval, pongWait time.Duration, missed *atomic.Bool, onPong func()) {
+	pongTimer := time.AfterFunc(interval+pongWait, func() {
+		missed.Store(true)
+		_ = w.c.Close()
+	})
+	w.c.SetPongHandler(func(string) error {
+		pongTimer.Reset(interval + pongWait)
+		onPong()
+		return nil
+	})
+
+	wg.Go(func() {
+		defer pongTimer.Stop()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.write(websocket.PingMessage, nil); err != nil {
+					return // The writer closed the connection, which ends the session
+				}
+			}
+		}
+	})
+}
+
+// sessionWriter sends every frame of a session, each bounded by write_timeout.
+// The first failure closes the connection and is kept, so a failed ping, which nothing waits on,
+// still ends the session promptly and is reported as the cause instead of the read error it provokes.
+type sessionWriter struct {
+	c       *websocket.Conn
+	timeout time.Duration
+
+	mu  sync.Mutex // Serializes writes, which the connection allows only one at a time
+	err error
+}
+
+func newSessionWriter(c *websocket.Conn, cfg *Config) *sessionWriter {
+	return &sessionWriter{c: c, timeout: time.Duration(cfg.WriteTimeout) * time.Second}
+}
+
+// write sends a data or control frame.
+func (w *sessionWriter) write(messageType int, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+
+	deadline := time.Now().Add(w.timeout)
+	var err error
+	if messageType == websocket.TextMessage || messageType == websocket.BinaryMessage {
+		if err = w.c.SetWriteDeadline(deadline); err == nil {
+			err = w.c.WriteMessage(messageType, data)
+		}
+	} else {
+		err = w.c.WriteControl(messageType, data, deadline)
+	}
+	if err != nil {
+		w.err = fmt.Errorf("writing %s frame failed: %w", frameTypeName(messageType), err)
+		_ = w.c.Close()
+	}
+	return w.err
+}
+
+// failed returns the write error that closed the connection, if any.
+func (w *sessionWriter) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// frameTypeName names a WebSocket message type for error messages.
+func frameTypeName(messageType int) string {
+	switch messageType {
+	case websocket.TextMessage:
+		return "text"
+	case websocket.BinaryMessage:
+		return "binary"
+	case websocket.PingMessage:
+		return "ping"
+	case websocket.PongMessage:
+		return "pong"
+	case websocket.CloseMessage:
+		return "close"
+	}
+	return fmt.Sprintf("type %d", messageType)
+}
+
+// runCommand executes the recovery command unless a previous run is still in progress.
+// With command_async, the command runs in the background and its result is reported on completion.
+// It returns the exit code and true when the command ran to completion before returning.
+func (m *monitor) runCommand(ctx context.Context, category string) (int, bool) {
+	if m.cfg.NotifyOnly {
+		// Announced once at startup; repeating it on every failure is noise
+		m.logDebugf("Notify-only mode: skipping command execution.")
+		return 0, false
+	}
+	if !m.onSchedule() {
+		m.logPrintf("Outside the monitoring schedule: skipping command execution.")
+		return 0, false
+	}
+	command := m.cfg.commandForCategory(m.target, category)
+	if strings.TrimSpace(command) == "" {
+		m.logPrintf("No command configured for %s failures: skipping command execution.", category)
+		return 0, false
+	}
+	if !m.commandRunning.CompareAndSwap(false, true) {
+		m.logWarnf("Previous command is still running. Skipping execution.")
+		return 0, false
+	}
+
+	if !m.cfg.CommandAsync {
+		defer m.commandRunning.Store(false)
+		m.logPrintf("Attempting to execute command...")
+		return m.executeCommandAndReport(ctx, command, category), true
+	}
+
+	m.logPrintf("Attempting to execute command in the background...")
+	go func() {
+		defer m.commandRunning.Store(false)
+		m.executeCommandAndReport(ctx, command, category)
+		m.logPrintf("Background command finished.")
+	}()
+	return 0, false
+}
+
+// executeCommandAndReport runs commandStr with the target and failure category in WATCHDOG_TARGET and WATCHDOG_CATEGORY.
+// It returns the exit code, which is -1 when the command could not be run.
+func (m *monitor) executeCommandAndReport(ctx context.Context, commandStr, category string) int {
+	parts := strings.Fields(commandStr)
+	if len(parts) == 0 {
+		m.logErrorf("Error: Recovery command string is empty")
+		return -1
+	}
+
+	// Traced so the duration histogram can link to the run through an exemplar
+	span := sentry.StartTransaction(sentry.SetHubOnContext(ctx, m.hub), "recovery command", sentry.WithOpName("command"))
+	defer span.Finish()
+	ctx = span.Context()
+
+	var cmd *exec.Cmd
+	if m.cfg.CommandShell {
+		cmd = shellCommand(ctx, commandStr)
+	} else {
+		cmd = exec.CommandContext(ctx, parts[0], parts[1:]...)
+	}
+	cmd.Env = append(os.Environ(), "WATCHDOG_TARGET="+m.url, "WATCHDOG_TARGET_NAME="+m.name, "WATCHDOG_CATEGORY="+category)
+	if m.procAttr != nil {
+		cmd.SysProcAttr = m.procAttr
+	}
+	if m.cfg.CommandStdin != "" {
+		cmd.Stdin = strings.NewReader(m.cfg.CommandStdin)
+	}
+	var out commandOutput
+	cmd.Stdout, cmd.Stderr = commandStream{&out, &out.stdout}, commandStream{&out, &out.stderr}
+	start := time.Now()
+	err := cmd.Run()
+	if m.procAttr != nil && errors.Is(err, syscall.EPERM) {
+		err = fmt.Errorf("not permitted to switch to command_user/command_group (the watchdog must run as root): %w", err)
+	}
+	duration := time.Since(start)
+	output := out.buf.String()
+
+	m.observeCommandDuration(duration, span)
+	m.recordCommand(start, duration, err, output)
+
+	writeLog(levelInfo, fmt.Sprintf("%sCommand Output:\n%s", m.prefix, output)) // The result line below carries the fields
+	result := []logField{
+		{"command", cmd.Args[0]},
+		{"args", cmd.Args[1:]},
+		{"exit_code", commandExitCode(err)},
+		{"duration_ms", duration.Milliseconds()},
+		{"stdout_bytes", out.stdout},
+		{"stderr_bytes", out.stderr},
+	}
+
+	if err != nil {
+		m.hub.WithScope(func(scope *sentry.Scope) {
+			scope.SetLevel(sentry.LevelFatal)
+			scope.SetSpan(span)
+			m.setCommandOutput(scope, output, duration)
+			m.hub.CaptureException(fmt.Errorf("command failed: %w", err))
+		})
+
+		writeLog(levelError, fmt.Sprintf("%scommand failed after %s: %v", m.prefix, duration, err), m.logFields(result...)...)
+	} else {
+		m.hub.WithScope(func(scope *sentry.Scope) {
+			scope.SetLevel(sentry.LevelInfo)
+			scope.SetSpan(span)
+			m.setCommandOutput(scope, output, duration)
+			m.hub.CaptureMessage(fmt.Sprintf("command executed successfully: %s", parts[0]))
+		})
+		writeLog(levelInfo, fmt.Sprintf("%scommand executed successfully in %s.", m.prefix, duration), m.logFields(result...)...)
+	}
+	return commandExitCode(err)
+}
+
+// commandOutput collects a command's stdout and stderr in one buffer, interleaved as they are written,
+// while counting the bytes of each stream.
+type commandOutput struct {
+	mu             sync.Mutex // The streams are copied by separate goroutines
+	buf            bytes.Buffer
+	stdout, stderr int
+}
+
+// commandStream writes one stream of a command into its commandOutput, counting the bytes in n.
+type commandStream struct {
+	out *commandOutput
+	n   *int
+}
+
+func (s commandStream) Write(p []byte) (int, error) {
+	s.out.mu.Lock()
+	defer s.out.mu.Unlock()
+	*s.n += len(p)
+	return s.out.buf.Write(p)
+}
+
+// setCommandOutput adds the command's output and duration to the event captured on scope.
+// With sentry.attach_output the output goes into an attachment, keeping its last MaxOutputAttachmentSize bytes,
+// since extras are truncated by Sentry long before that.
+func (m *monitor) setCommandOutput(scope *sentry.Scope, output string, duration time.Duration) {
+	scope.SetExtra("command_duration_seconds", duration.Seconds())
+	if !m.cfg.Sentry.AttachOutput {
+		scope.SetExtra("command_output", output)
+		return
+	}
+
+	if len(output) > MaxOutputAttachmentSize {
+		output = output[len(output)-MaxOutputAttachmentSize:]
+	}
+	scope.AddAttachment(&sentry.Attachment{
+		Filename:    "command-output.txt",
+		ContentType: "text/plain",
+		Payload:     []byte(output),
+	})
+}
+
+// observeCommandDuration records a command run in the duration histogram.
+// When Sentry tracing is active and /metrics is served, the observation carries the trace ID as an exemplar.
+func (m *monitor) observeCommandDuration(duration time.Duration, span *sentry.Span) {
+	observer := metricCommandDuration.WithLabelValues(m.name)
+	if !m.cfg.Sentry.Tracing || m.cfg.HTTP.Listen == "" || !span.Sampled.Bool() {
+		observer.Observe(duration.Seconds())
+		return
+	}
+	observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"trace_id": span.TraceID.String()})
+}
@@ -0,0 +1,73 @@
+package watchdog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+// TestSessionLeavesNoGoroutines runs a session with pings against the self-test's fake server until it times out,
+// and checks that the reader, the pinger and everything else the session started are gone once it returns.
+func TestSessionLeavesNoGoroutines(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(serveSelfTestStream))
+	defer srv.Close()
+
+	cfg := &Config{PingInterval: 1, NotifyOnly: true}
+	cfg.Target.URL = "ws" + strings.TrimPrefix(srv.URL, "http") + DefaultPath
+	cfg.Target.Timeout = 1
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fleet{}
+	m := newMonitor(cfg, cfg.Target, newDialer(cfg, nil), f, false)
+	f.monitors = []*monitor{m}
+	stats, err := m.startMonitoringSession(context.Background())
+	if stats.category != CategoryTimeout {
+		t.Fatalf("session ended with %v (category %s), want a timeout", err, stats.category)
+	}
+	if stats.notes != SelfTestNotes {
+		t.Errorf("got %d notes, want %d", stats.notes, SelfTestNotes)
+	}
+}
+
+// TestRetryableStatusSkipsCommand checks that a handshake rejected with one of retryable_statuses, as by a reverse
+// proxy while the instance restarts, is retried without running the command, even with reconnect_attempts at 0.
+func TestRetryableStatusSkipsCommand(t *testing.T) {
+	var handshakes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakes.Add(1)
+		http.Error(w, "upstream restarting", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	cfg := &Config{Command: "true", Timeout: 30, RetryableStatuses: []int{http.StatusBadGateway}}
+	cfg.Target.URL = "ws" + strings.TrimPrefix(srv.URL, "http") + DefaultPath
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fleet{}
+	m := newMonitor(cfg, cfg.Target, newDialer(cfg, nil), f, false)
+	f.monitors = []*monitor{m}
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+	m.run(ctx, ctx, ctx)
+
+	if n := handshakes.Load(); n < 2 {
+		t.Errorf("got %d handshakes, want the rejected one retried after reconnect_delay", n)
+	}
+	if st := m.status().LastCommand; st != nil {
+		t.Errorf("the command ran (exit code %d); want it skipped for a retryable status", st.ExitCode)
+	}
+}
@@ -0,0 +1,159 @@
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Failure categories assigned when a session ends, used to route notifications.
+const (
+	CategoryConnection  = "connection"  // Dialing or subscribing failed
+	CategoryAuth        = "auth"        // The handshake was rejected with 401 or 403
+	CategoryDNS         = "dns"         // The host name could not be resolved
+	CategorySubscribe   = "subscribe"   // The server never acknowledged the subscription
+	CategoryTimeout     = "timeout"     // No activity within the timeout
+	CategoryHalfOpen    = "half_open"   // A ping went unanswered
+	CategoryDisconnect  = "disconnect"  // The connection broke
+	CategoryClosed      = "closed"      // The server closed the connection cleanly
+	CategoryMalformed   = "malformed"   // Too many unparsable frames
+	CategoryThroughput  = "throughput"  // The note rate fell well below its baseline
+	CategoryMaintenance = "maintenance" // The server announced maintenance
+	CategoryCancelled   = "cancelled"   // The watchdog is shutting down
+	CategoryRecovered   = "recovered"   // Not a failure: the target came back
+	CategoryUnrecovered = "unrecovered" // No note arrived within verify_after_command after the command ran
+)
+
+// NotifyCategories lists the categories that can be used in notify.routes.
+var NotifyCategories = []string{
+	CategoryConnection, CategoryAuth, CategoryDNS, CategorySubscribe, CategoryTimeout, CategoryHalfOpen, CategoryDisconnect,
+	CategoryClosed, CategoryMalformed, CategoryThroughput, CategoryMaintenance, CategoryRecovered, CategoryUnrecovered,
+}
+
+// DefaultNotifyTimeout bounds each webhook delivery.
+const DefaultNotifyTimeout = 10 * time.Second
+
+// Webhook is a named notification endpoint that receives a JSON POST.
+// The payload's text field makes it work with Slack-compatible incoming webhooks as is.
+type Webhook struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+// notification is the JSON body posted to webhooks.
+type notification struct {
+	Text        string `json:"text"`
+	Target      string `json:"target"` // The target's name
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+	Category    string `json:"category"`
+}
+
+// webhooksFor returns the names of the webhooks a category is routed to, falling back to notify.default.
+func (cfg *Config) webhooksFor(category string) []string {
+	if names, ok := cfg.Notify.Routes[category]; ok {
+		return names
+	}
+	return cfg.Notify.Default
+}
+
+// notify posts text to every webhook routed for category, in the background.
+func (m *monitor) notify(category, text string) {
+	go m.notifyAndWait(category, text)
+}
+
+// notifyAndWait posts text to every webhook routed for category at once and returns the outcome by webhook name
+// when all of them are done.
+func (m *monitor) notifyAndWait(category, text string) map[string]error {
+	body, _ := json.Marshal(notification{Text: text, Target: m.name, URL: m.url, Description: m.target.Description, Category: category})
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]error{}
+	for _, name := range m.cfg.webhooksFor(category) {
+		i := slices.IndexFunc(m.cfg.Notify.Webhooks, func(w Webhook) bool { return w.Name == name })
+		if i < 0 {
+			continue // Rejected by Validate
+		}
+		wg.Go(func() {
+			err := m.postWebhook(m.cfg.Notify.Webhooks[i], body)
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = err
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+func (m *monitor) postWebhook(w Webhook, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultNotifyTimeout)
+	defer cancel()
+
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}()
+	// The URL is left out, as webhook URLs usually embed a secret
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	if err != nil {
+		m.logWarnf("Notification to webhook %q failed: %v", w.Name, err)
+	}
+	return err
+}
+
+// validateNotify checks that every route refers to a known category and webhook.
+func validateNotify(cfg *Config) []error {
+	var errs []error
+	names := make([]string, 0, len(cfg.Notify.Webhooks))
+	for i, w := range cfg.Notify.Webhooks {
+		if w.Name == "" || !isWebhookURL(w.URL) {
+			errs = append(errs, fmt.Errorf("notify.webhooks[%d]: name and an http(s) url must be specified", i))
+		}
+		if slices.Contains(names, w.Name) {
+			errs = append(errs, fmt.Errorf("notify.webhooks[%d]: duplicate name %q", i, w.Name))
+		}
+		names = append(names, w.Name)
+	}
+
+	check := func(path string, refs []string) {
+		for _, ref := range refs {
+			if !slices.Contains(names, ref) {
+				errs = append(errs, fmt.Errorf("%s: unknown webhook %q", path, ref))
+			}
+		}
+	}
+	check("notify.default", cfg.Notify.Default)
+	for category, refs := range cfg.Notify.Routes {
+		if !slices.Contains(NotifyCategories, category) {
+			errs = append(errs, fmt.Errorf("notify.routes: unknown category %q", category))
+		}
+		check("notify.routes."+category, refs)
+	}
+	return errs
+}
+
+func isWebhookURL(s string) bool {
+	return IsRemoteConfig(s) // Same http(s) check as for remote configuration
+}
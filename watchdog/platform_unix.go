@@ -0,0 +1,13 @@
+//go:build unix
+
+package watchdog
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs commandStr through /bin/sh, for command_shell.
+func shellCommand(ctx context.Context, commandStr string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", commandStr)
+}
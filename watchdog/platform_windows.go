@@ -0,0 +1,17 @@
+//go:build windows
+
+package watchdog
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs commandStr through cmd.exe, for command_shell.
+// The command line is passed through untouched, since cmd.exe does not follow the usual argument quoting rules.
+func shellCommand(ctx context.Context, commandStr string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `/C ` + commandStr}
+	return cmd
+}
@@ -0,0 +1,75 @@
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RunPreflight connects and subscribes to every node of every target, retrying each up to preflight_attempts times
+// so that a watchdog started alongside its instance doesn't fail while the instance is still coming up.
+// It prints a pass/fail line per node and returns the process exit code.
+func RunPreflight(ctx context.Context, cfg *Config) int {
+	dialer := newDialer(cfg, nil)
+	f := &fleet{}
+	passed := true
+	for _, t := range cfg.targetList() {
+		m := newMonitor(cfg, t, dialer, f, false)
+		for _, node := range m.nodes.nodes {
+			err := m.preflight(ctx, node.URL)
+			result, detail := "PASS", "connected and subscribed"
+			if err != nil {
+				result, detail, passed = "FAIL", err.Error(), false
+			}
+			fmt.Printf("%s  %s: %s\n", result, node.URL, detail)
+		}
+	}
+
+	if !passed {
+		return 1
+	}
+	return 0
+}
+
+// preflight makes up to preflight_attempts connection attempts to node, logging each of them,
+// and returns the error of the last attempt.
+func (m *monitor) preflight(ctx context.Context, node string) error {
+	attempts, interval := m.cfg.PreflightAttempts, time.Duration(m.cfg.PreflightInterval)*time.Second
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = m.checkNode(ctx, node); err == nil {
+			m.logPrintf("Preflight attempt %d/%d to %s succeeded.", attempt, attempts, node)
+			return nil
+		}
+		m.logWarnf("Preflight attempt %d/%d to %s failed: %v", attempt, attempts, node, err)
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(interval):
+		}
+	}
+	return err
+}
+
+// checkNode connects to node and sends the subscription, bounded by the handshake timeout.
+func (m *monitor) checkNode(ctx context.Context, node string) error {
+	if m.dialer.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.dialer.HandshakeTimeout)
+		defer cancel()
+	}
+	c, _, err := m.dial(ctx, node)
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", err)
+	}
+	defer c.Close()
+	if err := newSessionWriter(c, m.cfg).write(websocket.TextMessage, subscribePayload(m.subscribeID)); err != nil {
+		return fmt.Errorf("subscribe failed: %w", err)
+	}
+	return nil
+}
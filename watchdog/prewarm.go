@@ -0,0 +1,163 @@
+package watchdog
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MaxTicketWait bounds how long a prewarm handshake waits for the session tickets that TLS 1.3 servers
+// send after the handshake.
+const MaxTicketWait = time.Second
+
+// warmCache keeps the addresses that the prewarm probes resolved for each host, so a reconnection can
+// skip the DNS lookup. TLS session tickets are kept by the dialer's session cache instead.
+type warmCache struct {
+	interval time.Duration // prewarm_interval; addresses older than two are looked up again, in case the probes stopped working
+
+	mu    sync.Mutex
+	hosts map[string]warmAddrs
+}
+
+type warmAddrs struct {
+	ips      []string
+	resolved time.Time
+}
+
+func newWarmCache(interval time.Duration) *warmCache {
+	return &warmCache{interval: interval, hosts: map[string]warmAddrs{}}
+}
+
+func (w *warmCache) store(host string, ips []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hosts[host] = warmAddrs{ips, time.Now()}
+}
+
+// lookup returns the addresses of host resolved by the last prewarm, or nil when there are none or they are stale.
+func (w *warmCache) lookup(host string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cached, ok := w.hosts[host]
+	if !ok || time.Since(cached.resolved) > 2*w.interval {
+		return nil
+	}
+	return cached.ips
+}
+
+// dial connects to addr through its prewarmed addresses. It returns false when there are none or none of
+// them answers, leaving it to a regular dial to look the host up again.
+func (w *warmCache) dial(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false
+	}
+	for _, ip := range w.lookup(host) {
+		c, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			logDebugf("Dialed %s through its prewarmed address %s", addr, ip)
+			return c, true
+		}
+		logDebugf("Dialing prewarmed address %s of %s failed: %v", ip, host, err)
+	}
+	return nil, false
+}
+
+// prewarm resolves every node of the fleet and completes a TLS handshake with every wss node each
+// prewarm_interval until ctx is done, so that a reconnection finds the addresses and a session ticket to resume
+// ready. Nodes reached through a proxy or a Unix socket are skipped, as their connections don't use either.
+func (f *fleet) prewarm(ctx context.Context) {
+	ticker := time.NewTicker(f.warm.interval)
+	defer ticker.Stop()
+	for {
+		for _, m := range f.monitors {
+			for _, node := range m.nodes.nodes {
+				m.prewarmNode(ctx, node.URL)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *monitor) prewarmNode(ctx context.Context, node string) {
+	u, err := url.Parse(node)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		return
+	}
+	proxyURL := *u
+	proxyURL.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	if proxy, _ := http.ProxyFromEnvironment(&http.Request{URL: &proxyURL}); proxy != nil {
+		return
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = map[string]string{"ws": "80", "wss": "443"}[u.Scheme]
+	}
+	if net.ParseIP(host) == nil {
+		lookupCtx, cancel := context.WithTimeout(ctx, m.prewarmTimeout())
+		ips, err := net.DefaultResolver.LookupIP(lookupCtx, strings.Replace(m.cfg.Dialer.Network, "tcp", "ip", 1), host)
+		cancel()
+		if err != nil {
+			m.logDebugf("Prewarming %s: DNS lookup failed: %v", host, err)
+			return
+		}
+		addrs := make([]string, len(ips))
+		for i, ip := range ips {
+			addrs[i] = ip.String()
+		}
+		m.fleet.warm.store(host, addrs)
+	}
+
+	if u.Scheme == "wss" {
+		tlsCtx, cancel := context.WithTimeout(ctx, m.prewarmTimeout())
+		defer cancel()
+		if err := warmTLS(tlsCtx, m.dialer, host, port); err != nil {
+			if ctx.Err() != nil {
+				return // Shutting down
+			}
+			m.logDebugf("Prewarming %s: TLS handshake failed: %v", host, err)
+			return
+		}
+	}
+	m.logDebugf("Prewarmed %s.", host)
+}
+
+// prewarmTimeout bounds each step of a prewarm probe like the handshake of a regular connection.
+func (m *monitor) prewarmTimeout() time.Duration {
+	if m.dialer.HandshakeTimeout > 0 {
+		return m.dialer.HandshakeTimeout
+	}
+	return DefaultHandshakeTimeout
+}
+
+// warmTLS completes a TLS handshake with host the way dialer would, filling its session cache, and hangs up.
+func warmTLS(ctx context.Context, dialer *websocket.Dialer, host, port string) error {
+	conn, err := dialer.NetDialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	cfg := dialer.TLSClientConfig.Clone()
+	cfg.ServerName = host // The session cache key, as set by the dialer
+	tlsConn := tls.Client(conn, cfg)
+	defer tlsConn.Close()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+
+	// TLS 1.3 tickets arrive after the handshake and are only taken in while reading
+	_ = tlsConn.SetReadDeadline(time.Now().Add(MaxTicketWait))
+	_, _ = tlsConn.Read(make([]byte, 1))
+	return nil
+}
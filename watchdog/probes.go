@@ -0,0 +1,91 @@
+package watchdog
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LivenessGrace is how long a monitor loop may overrun the wait it announced before /livez fails.
+const LivenessGrace = 30 * time.Second
+
+// expectBeat announces that the monitor loop is about to block for at most d, after which it must
+// come back around. 0 means there is no bound, e.g. while a command without a time limit runs.
+func (m *monitor) expectBeat(d time.Duration) {
+	if d <= 0 {
+		m.beatDeadline.Store(0)
+		return
+	}
+	m.beatDeadline.Store(time.Now().Add(d).UnixNano())
+}
+
+// stalled reports whether the monitor loop has overrun its announced wait, meaning it is stuck.
+func (m *monitor) stalled(now time.Time) bool {
+	deadline := m.beatDeadline.Load()
+	return deadline != 0 && now.After(time.Unix(0, deadline).Add(LivenessGrace))
+}
+
+// setReceiving records whether notes are arriving on the current session, for /readyz and watchdog_target_up.
+func (m *monitor) setReceiving(receiving bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.receiving = receiving
+	m.updateTargetUp()
+}
+
+// livenessProbe passes while every monitor loop is making progress; failing it should restart the process.
+func livenessProbe(monitors []*monitor) (bool, []string) {
+	var stalled []string
+	now := time.Now()
+	for _, m := range monitors {
+		if m.stalled(now) {
+			stalled = append(stalled, m.name)
+		}
+	}
+	return len(stalled) == 0, stalled
+}
+
+// readinessProbe applies the health policy to the targets that are connected and receiving notes.
+// Unlike /healthz it fails while every target is idling outside the monitoring schedule, as nothing is watched then.
+func readinessProbe(policy string, monitors []*monitor) (bool, []string) {
+	var notReady []string
+	ready, active := 0, 0
+	for _, m := range monitors {
+		m.mu.Lock()
+		off, receiving := m.scheduledOff, m.up && m.receiving
+		m.mu.Unlock()
+
+		if off {
+			continue
+		}
+		active++
+		if receiving {
+			ready++
+		} else {
+			notReady = append(notReady, m.name)
+		}
+	}
+
+	switch {
+	case active == 0:
+		return false, nil
+	case policy == HealthPolicyAny:
+		return ready > 0, notReady
+	case policy == HealthPolicyMajority:
+		return ready*2 > active, notReady
+	default:
+		return ready == active, notReady
+	}
+}
+
+// writeProbe answers a probe with 200 or 503 and lists the targets responsible for a failure.
+func writeProbe(w http.ResponseWriter, ok bool, failing []string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if ok {
+		_, _ = w.Write([]byte("ok\n"))
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write([]byte(strings.Join(append([]string{"failing"}, failing...), "\n") + "\n"))
+}
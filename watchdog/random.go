@@ -0,0 +1,40 @@
+package watchdog
+
+import (
+	cryptorand "crypto/rand"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+)
+
+// random is the source of every random choice, such as node selection and startup_jitter.
+// It is randomly seeded unless replaced by SetRandomSource.
+var random = rand.New(&lockedSource{src: rand.NewPCG(rand.Uint64(), rand.Uint64())})
+
+// SetRandomSource replaces the source of every random choice, e.g. with rand.NewPCG(1, 2) for reproducible
+// node selection and jitter in tests. Call it before Run.
+func SetRandomSource(src rand.Source) {
+	random = rand.New(&lockedSource{src: src})
+}
+
+// lockedSource makes a source safe for the concurrent use of all monitors; rand.Rand keeps no other state.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+// newUUID returns a random (version 4) UUID. It doesn't use random, as the ids have to stay unique
+// across processes even with a fixed source.
+func newUUID() string {
+	var b [16]byte
+	_, _ = cryptorand.Read(b[:]) // Never fails
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,70 @@
+package watchdog
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MaxReconnectHint caps a server-suggested reconnect interval, so a bogus hint can't stall monitoring for long.
+const MaxReconnectHint = 24 * time.Hour
+
+// reconnectHintPattern matches hints such as "retry after 120", "reconnect in 5m" or "retry-after=30s" in close reasons.
+var reconnectHintPattern = regexp.MustCompile(`(?i)\b(?:retry|reconnect)(?:[ _-]?(?:after|in))?\s*[:=]?\s*(\d+)\s*(ms|s|m|h)?\b`)
+
+// reconnectHint extracts the interval a server suggested in the reason of the close frame that ended err.
+func reconnectHint(err error) (time.Duration, bool) {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return 0, false
+	}
+	match := reconnectHintPattern.FindStringSubmatch(closeErr.Text)
+	if match == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, false
+	}
+	unit := map[string]time.Duration{"ms": time.Millisecond, "": time.Second, "s": time.Second, "m": time.Minute, "h": time.Hour}[match[2]]
+	return capReconnectHint(time.Duration(n) * unit)
+}
+
+// retryAfterHint reads the Retry-After header (seconds or an HTTP date) of a rejected handshake.
+func retryAfterHint(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return capReconnectHint(time.Duration(seconds) * time.Second)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return capReconnectHint(time.Until(at))
+	}
+	return 0, false
+}
+
+func capReconnectHint(d time.Duration) (time.Duration, bool) {
+	if d <= 0 {
+		return 0, false
+	}
+	return min(d, MaxReconnectHint), true
+}
+
+// reconnectWait returns the interval the server suggested when the session ended, or fallback without one.
+func (m *monitor) reconnectWait(stats sessionStats, fallback time.Duration) time.Duration {
+	if stats.reconnectHint <= 0 {
+		return fallback
+	}
+	m.logPrintf("Using the server's suggested reconnect interval of %s instead of %s.", stats.reconnectHint, fallback)
+	return stats.reconnectHint
+}
@@ -0,0 +1,82 @@
+package watchdog
+
+import (
+	"context"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// MaxScheduleWindow bounds the search for the end of a monitoring window, so an always-on schedule
+// still reconnects occasionally to re-evaluate it.
+const MaxScheduleWindow = 7 * 24 * time.Hour
+
+// parseSchedule parses a standard five-field cron expression, optionally prefixed with CRON_TZ=<zone>.
+// An empty expression means monitoring is always on and yields a nil schedule.
+func parseSchedule(expr string) (cron.Schedule, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return cron.ParseStandard(expr)
+}
+
+// inWindow reports whether the minute containing t matches the schedule.
+// A schedule like "* 9-17 * * 1-5" therefore means "every minute of business hours".
+func inWindow(s cron.Schedule, t time.Time) bool {
+	minute := t.Truncate(time.Minute)
+	return s.Next(minute.Add(-time.Second)).Equal(minute)
+}
+
+// windowEnd returns the first minute after t that no longer matches the schedule.
+func windowEnd(s cron.Schedule, t time.Time) time.Time {
+	end := t.Truncate(time.Minute)
+	for limit := t.Add(MaxScheduleWindow); end.Before(limit) && inWindow(s, end); {
+		end = end.Add(time.Minute)
+	}
+	return end
+}
+
+func (m *monitor) onSchedule() bool {
+	return m.schedule == nil || inWindow(m.schedule, time.Now())
+}
+
+// waitForSchedule idles until the next monitoring window opens.
+// It returns false if ctx is cancelled first.
+func (m *monitor) waitForSchedule(ctx context.Context) bool {
+	m.setScheduledOff(true)
+	defer m.setScheduledOff(false)
+
+	next := m.schedule.Next(time.Now())
+	var wait <-chan time.Time // Never fires when the schedule has no future match
+	if !next.IsZero() {
+		m.logPrintf("Outside the monitoring schedule. Idling until %s.", next.Format(time.RFC3339))
+		wait = time.After(time.Until(next))
+		m.expectBeat(time.Until(next))
+	} else {
+		m.expectBeat(0)
+		m.logWarnf("The monitoring schedule never matches. Idling until shutdown.")
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-wait:
+		m.logPrintf("Monitoring window opened.")
+		return true
+	}
+}
+
+// scheduleContext derives a context that is cancelled when the current monitoring window closes.
+func (m *monitor) scheduleContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.schedule == nil {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, windowEnd(m.schedule, time.Now()))
+}
+
+func (m *monitor) setScheduledOff(off bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scheduledOff = off
+}
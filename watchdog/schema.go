@@ -0,0 +1,179 @@
+package watchdog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSchema returns a JSON Schema of the configuration file for editors and CI linters.
+// It is derived from the Config struct, as is the check run when loading, so neither can drift from what is read.
+func ConfigSchema() []byte {
+	schema := typeSchema(reflect.TypeFor[Config]())
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "misskey-timeline-watchdog configuration"
+	out, _ := json.MarshalIndent(schema, "", "  ") // Can't fail: only maps, slices, strings and bools
+	return append(out, '\n')
+}
+
+// yamlField is a struct field as it appears in the configuration file.
+type yamlField struct {
+	key string
+	typ reflect.Type
+}
+
+// yamlFields returns the settings of a configuration struct, keyed by their yaml tag.
+func yamlFields(t reflect.Type) []yamlField {
+	var fields []yamlField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if key == "-" || !f.IsExported() {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		fields = append(fields, yamlField{key, f.Type})
+	}
+	return fields
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for _, f := range yamlFields(t) {
+			properties[f.key] = typeSchema(f.typ)
+		}
+		schema := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+		if t == reflect.TypeFor[TargetURL]() {
+			return map[string]any{"anyOf": []any{map[string]any{"type": "string"}, schema}} // See TargetURL.UnmarshalYAML
+		}
+		return schema
+	}
+	return map[string]any{}
+}
+
+// checkConfigNode reports every value of a configuration document that Config has no place for,
+// such as a misspelled key or a list where a number belongs, each with its dotted path.
+func checkConfigNode(root *yaml.Node) error {
+	var errs []error
+	checkNode(root, reflect.TypeFor[Config](), "", &errs)
+	return errors.Join(errs...)
+}
+
+func checkNode(node *yaml.Node, t reflect.Type, path string, errs *[]error) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return // Leaves the zero value, like an absent key
+	}
+	fail := func(format string, v ...any) {
+		*errs = append(*errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, v...)))
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			fail("must be true or false%s", got(node))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!int" {
+			fail("must be an integer%s", got(node))
+		}
+	case reflect.Float32, reflect.Float64:
+		if node.Kind != yaml.ScalarNode || (node.Tag != "!!int" && node.Tag != "!!float") {
+			fail("must be a number%s", got(node))
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			fail("must be a string%s", got(node))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			fail("must be a list%s", got(node))
+			return
+		}
+		for i, item := range node.Content {
+			checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			fail("must be a mapping%s", got(node))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkNode(node.Content[i+1], t.Elem(), joinKey(path, node.Content[i].Value), errs)
+		}
+	case reflect.Struct:
+		if t == reflect.TypeFor[TargetURL]() && node.Kind == yaml.ScalarNode {
+			return // A plain URL
+		}
+		if node.Kind != yaml.MappingNode {
+			fail("must be a mapping%s", got(node))
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if key == "<<" {
+				checkMerge(value, t, path, errs)
+				continue
+			}
+			j := slices.IndexFunc(fields, func(f yamlField) bool { return f.key == key })
+			if j < 0 {
+				*errs = append(*errs, fmt.Errorf("%s: unknown setting", joinKey(path, key)))
+				continue
+			}
+			checkNode(value, fields[j].typ, joinKey(path, key), errs)
+		}
+	}
+}
+
+// checkMerge checks the mappings merged into a struct with a YAML merge key (<<: *anchor).
+func checkMerge(value *yaml.Node, t reflect.Type, path string, errs *[]error) {
+	if value.Kind == yaml.SequenceNode {
+		for _, item := range value.Content {
+			checkNode(item, t, path, errs)
+		}
+		return
+	}
+	checkNode(value, t, path, errs)
+}
+
+// got describes a mistyped value for an error message.
+func got(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return ", not a list"
+	case yaml.MappingNode:
+		return ", not a mapping"
+	}
+	return fmt.Sprintf(" (got %q)", node.Value)
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,160 @@
+package watchdog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	SelfTestNotes    = 3                      // Notes the fake server sends before going silent
+	SelfTestInterval = 100 * time.Millisecond // Between those notes
+	SelfTestTimeout  = 2                      // Seconds of silence before the simulated failure is detected
+	SelfTestWebhook  = "watchdog-self-test"   // Name of the local webhook that checks the notification payload
+)
+
+// sentEvents counts the events handed to the Sentry transport, so -self-test can check that reporting works.
+var sentEvents atomic.Int64
+
+// RunSelfTest drives the first target's failure path once against an in-process fake streaming server:
+// it connects, receives a few notes, detects the silence, reports and notifies it and runs the configured command.
+// The notification also goes to a local receiver that checks its payload.
+// It prints a pass/fail summary and returns the process exit code.
+func RunSelfTest(cfg *Config) int {
+	srv := httptest.NewServer(http.HandlerFunc(serveSelfTestStream))
+	defer srv.Close()
+	received := make(chan notification, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case received <- n:
+		default:
+		}
+	}))
+	defer receiver.Close()
+
+	// Only the connection is faked; the command, Sentry and the rest of the configuration are real
+	t := cfg.targetList()[0]
+	t.Domain, t.URLs, t.Token = "", nil, ""
+	t.URL = "ws" + strings.TrimPrefix(srv.URL, "http") + DefaultPath
+	t.Timeout = SelfTestTimeout
+	testCfg := *cfg
+	testCfg.StartupGrace = 0
+	testCfg.Schedule = ""
+	testCfg.CommandAsync = false
+	testCfg.Notify.Webhooks = append(slices.Clone(cfg.Notify.Webhooks), Webhook{Name: SelfTestWebhook, URL: receiver.URL})
+
+	f := &fleet{}
+	m := newMonitor(&testCfg, t, newDialer(&testCfg, nil), f, false)
+	f.monitors = []*monitor{m}
+
+	logPrintf("Self-test: simulating a silent timeline on %s...", t.URL)
+	ctx := context.Background()
+	eventsBefore := sentEvents.Load()
+	stats, err := m.startMonitoringSession(ctx)
+	m.setState(false, err)
+	m.reportSession(stats, err, levelError)
+	webhooks := testCfg.webhooksFor(stats.category)
+	testCfg.Notify.Routes = maps.Clone(cfg.Notify.Routes)
+	if testCfg.Notify.Routes == nil {
+		testCfg.Notify.Routes = map[string][]string{}
+	}
+	testCfg.Notify.Routes[stats.category] = append(slices.Clone(webhooks), SelfTestWebhook)
+	delivered := m.notifyAndWait(stats.category, fmt.Sprintf("Self-test: %starget failed (%s): %v", m.prefix, stats.category, err))
+	m.runCommand(ctx, stats.category)
+
+	passed := true
+	check := func(name string, ok bool, detail string) {
+		result := "PASS"
+		if !ok {
+			result, passed = "FAIL", false
+		}
+		fmt.Printf("%s  %-18s %s\n", result, name, detail)
+	}
+
+	fmt.Println("Self-test summary:")
+	check("connect", stats.messages >= SelfTestNotes, fmt.Sprintf("%d of %d notes received", stats.messages, SelfTestNotes))
+	check("failure detection", err != nil && !isCleanClose(err) && !isMaintenance(err), fmt.Sprint(err))
+
+	select {
+	case n := <-received:
+		check("notification", n.Category == stats.category && n.Target == m.name, fmt.Sprintf("%s notification for %s received", n.Category, n.Target))
+	default:
+		check("notification", false, fmt.Sprintf("nothing received: %v", delivered[SelfTestWebhook]))
+	}
+	if len(webhooks) == 0 {
+		fmt.Printf("SKIP  %-18s no webhook is routed for %s failures\n", "webhooks", stats.category)
+	}
+	for _, name := range webhooks {
+		err := delivered[name]
+		detail := "delivered"
+		if err != nil {
+			detail = err.Error()
+		}
+		check("webhook "+name, err == nil, detail)
+	}
+
+	switch st := m.status().LastCommand; {
+	case cfg.NotifyOnly:
+		fmt.Printf("SKIP  %-18s notify_only is enabled\n", "command")
+	case st == nil:
+		check("command", false, "the command did not run")
+	default:
+		check("command", st.ExitCode == 0, fmt.Sprintf("exit code %d after %.2fs", st.ExitCode, st.DurationSeconds))
+	}
+
+	if sentry.CurrentHub().Client() == nil {
+		fmt.Printf("SKIP  %-18s sentry.dsn is not set\n", "sentry")
+	} else {
+		flushed := FlushSentry()
+		events := sentEvents.Load() - eventsBefore
+		check("sentry", flushed && events > 0, fmt.Sprintf("%d events sent (flushed: %t)", events, flushed))
+	}
+
+	if !passed {
+		return 1
+	}
+	return 0
+}
+
+// serveSelfTestStream accepts the subscription, sends a few notes and then stays silent until the client gives up.
+func serveSelfTestStream(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	if _, _, err := c.ReadMessage(); err != nil {
+		return
+	}
+	for i := range SelfTestNotes {
+		note := fmt.Sprintf(`{"type":"channel","body":{"id":"1","type":"note","body":{"id":"selftest%d","visibility":"public"}}}`, i)
+		if err := c.WriteMessage(websocket.TextMessage, []byte(note)); err != nil {
+			return
+		}
+		time.Sleep(SelfTestInterval)
+	}
+
+	// Drain until the watchdog closes the connection
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// sentryDSNs returns the valid DSNs of the sentry section, dsn first. Invalid ones are reported and left out,
+// so a typo in one project's DSN doesn't cost the others their events.
+func sentryDSNs(cfg *Config) []string {
+	var dsns []string
+	for i, dsn := range append([]string{cfg.Sentry.DSN}, cfg.Sentry.DSNs...) {
+		if dsn == "" || slices.Contains(dsns, dsn) {
+			continue
+		}
+		if _, err := sentry.NewDsn(dsn); err != nil {
+			key := "sentry.dsn"
+			if i > 0 {
+				key = fmt.Sprintf("sentry.dsns[%d]", i-1)
+			}
+			writeLog(levelWarn, fmt.Sprintf("Sentry DSN skipped: %s: %v", key, err)) // Not the DSN itself, which holds the key
+			continue
+		}
+		dsns = append(dsns, dsn)
+	}
+	return dsns
+}
+
+// fanoutTransport sends every event through one HTTP transport per DSN, so that each project receives
+// the same events with the same scope and tags.
+type fanoutTransport struct {
+	transports []sentry.Transport
+}
+
+func newFanoutTransport(options sentry.ClientOptions, dsns []string) *fanoutTransport {
+	t := &fanoutTransport{}
+	for _, dsn := range dsns {
+		options.Dsn = dsn
+		transport := sentry.NewHTTPTransport()
+		transport.Configure(options)
+		t.transports = append(t.transports, transport)
+	}
+	return t
+}
+
+// Configure does nothing, as every transport was configured with its own DSN by newFanoutTransport.
+func (t *fanoutTransport) Configure(sentry.ClientOptions) {}
+
+func (t *fanoutTransport) SendEvent(event *sentry.Event) {
+	for _, transport := range t.transports {
+		transport.SendEvent(event) // Serialized before returning, so sharing the event is safe
+	}
+}
+
+func (t *fanoutTransport) Flush(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return t.FlushWithContext(ctx)
+}
+
+// FlushWithContext flushes every transport at once and reports whether all of them finished.
+func (t *fanoutTransport) FlushWithContext(ctx context.Context) bool {
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	for _, transport := range t.transports {
+		wg.Go(func() {
+			if !transport.FlushWithContext(ctx) {
+				failed.Store(true)
+			}
+		})
+	}
+	wg.Wait()
+	return !failed.Load()
+}
+
+func (t *fanoutTransport) Close() {
+	for _, transport := range t.transports {
+		transport.Close()
+	}
+}
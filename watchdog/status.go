@@ -0,0 +1,225 @@
+package watchdog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	HealthPolicyAll      = "all"
+	HealthPolicyAny      = "any"
+	HealthPolicyMajority = "majority"
+
+	StatusHealthy      = "healthy"
+	StatusDegraded     = "degraded"
+	StatusUnhealthy    = "unhealthy"
+	StatusScheduledOff = "scheduled-off" // Every target is idling outside the monitoring schedule
+
+	MaxStatusOutputSize = 512 // Bytes of command output shown in /status
+)
+
+type targetStatus struct {
+	Name          string         `json:"name"`
+	Description   string         `json:"description,omitempty"`
+	URL           string         `json:"url"`
+	Up            bool           `json:"up"`
+	Since         time.Time      `json:"since"`
+	LastError     string         `json:"last_error,omitempty"`
+	ScheduledOff  bool           `json:"scheduled_off,omitempty"`
+	Maintenance   bool           `json:"maintenance,omitempty"`
+	LastCommand   *commandStatus `json:"last_command,omitempty"`
+	FailureStreak int            `json:"consecutive_failures"`
+	NextAttempt   *time.Time     `json:"next_attempt,omitempty"` // Set while waiting to reconnect
+	Cooldown      float64        `json:"cooldown_remaining_seconds,omitempty"`
+	BytesReceived int64          `json:"bytes_received"`
+}
+
+// commandStatus describes the most recent run of a target's recovery command.
+type commandStatus struct {
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	ExitCode        int       `json:"exit_code"` // -1 when the command could not be started or was killed
+	Output          string    `json:"output"`    // The last MaxStatusOutputSize bytes
+}
+
+type statusResponse struct {
+	Status  string         `json:"status"`
+	Healthy bool           `json:"healthy"`
+	Targets []targetStatus `json:"targets"`
+}
+
+func (m *monitor) status() targetStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var nextAttempt *time.Time
+	var cooldown float64
+	if !m.nextAttempt.IsZero() {
+		next := m.nextAttempt
+		nextAttempt = &next
+		cooldown = max(time.Until(next), 0).Seconds()
+	}
+	return targetStatus{
+		Name:          m.name,
+		Description:   m.target.Description,
+		URL:           m.url,
+		Up:            m.up,
+		Since:         m.since,
+		LastError:     m.lastError,
+		ScheduledOff:  m.scheduledOff,
+		Maintenance:   m.maintenance,
+		LastCommand:   m.lastCommand,
+		FailureStreak: m.failureStreak,
+		NextAttempt:   nextAttempt,
+		Cooldown:      cooldown,
+		BytesReceived: m.bytesReceived.Load(),
+	}
+}
+
+// recordCommand keeps the result of a finished recovery command for /status and the metrics.
+func (m *monitor) recordCommand(start time.Time, duration time.Duration, err error, output string) {
+	exitCode := commandExitCode(err)
+	if len(output) > MaxStatusOutputSize {
+		output = output[len(output)-MaxStatusOutputSize:]
+	}
+
+	m.recordEvent(EventCommand, "", fmt.Sprintf("exit code %d after %s", exitCode, duration.Round(time.Millisecond)))
+	metricLastCommandExitCode.WithLabelValues(m.name).Set(float64(exitCode))
+	metricLastCommandTimestamp.WithLabelValues(m.name).Set(float64(start.Unix()))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Replaced rather than updated, so status() can hand out the pointer
+	m.lastCommand = &commandStatus{
+		Time:            start,
+		DurationSeconds: duration.Seconds(),
+		ExitCode:        exitCode,
+		Output:          output,
+	}
+}
+
+// commandExitCode returns the exit code of a finished command, or -1 when it could not be run or was killed.
+func commandExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// aggregateStatus summarizes the state of all targets.
+// The overall status is healthy when every target is up, unhealthy when none is, and degraded otherwise;
+// policy decides which of these still count as passing the health check.
+// Targets idling outside the monitoring schedule are left out, and when all of them are, the check passes.
+func aggregateStatus(policy string, monitors []*monitor) statusResponse {
+	resp := statusResponse{Targets: make([]targetStatus, 0, len(monitors))}
+	upCount, activeCount := 0, 0
+	for _, m := range monitors {
+		st := m.status()
+		if !st.ScheduledOff {
+			activeCount++
+		}
+		if st.Up {
+			upCount++
+		}
+		resp.Targets = append(resp.Targets, st)
+	}
+
+	if activeCount == 0 {
+		resp.Status = StatusScheduledOff
+		resp.Healthy = true
+		return resp
+	}
+
+	switch upCount {
+	case activeCount:
+		resp.Status = StatusHealthy
+	case 0:
+		resp.Status = StatusUnhealthy
+	default:
+		resp.Status = StatusDegraded
+	}
+
+	switch policy {
+	case HealthPolicyAny:
+		resp.Healthy = upCount > 0
+	case HealthPolicyMajority:
+		resp.Healthy = upCount*2 > activeCount
+	default:
+		resp.Healthy = upCount == activeCount
+	}
+	return resp
+}
+
+// newHTTPServer returns the server of the HTTP endpoints. It serves the status of whichever fleet is current,
+// so it keeps working across configuration reloads.
+func newHTTPServer(cfg *Config, current *atomic.Pointer[fleet]) *http.Server {
+	policy := cfg.HTTP.HealthPolicy
+	healthBody := template.Must(template.New("health_body").Parse(cfg.HTTP.HealthBody)) // Already checked by Validate
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		resp := aggregateStatus(policy, current.Load().monitors)
+
+		var body bytes.Buffer
+		if err := healthBody.Execute(&body, resp); err != nil {
+			http.Error(w, fmt.Sprintf("health_body: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", cfg.HTTP.HealthContentType)
+		if resp.Healthy {
+			w.WriteHeader(cfg.HTTP.HealthOKStatus)
+		} else {
+			w.WriteHeader(cfg.HTTP.HealthFailStatus)
+		}
+		_, _ = w.Write(body.Bytes())
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(current.Load().events.list())
+	})
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		ok, stalled := livenessProbe(current.Load().monitors)
+		writeProbe(w, ok, stalled)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ok, notReady := readinessProbe(policy, current.Load().monitors)
+		writeProbe(w, ok, notReady)
+	})
+	// OpenMetrics is needed for the exemplars on watchdog_command_duration_seconds
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		resp := aggregateStatus(policy, current.Load().monitors)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
+	return &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// serveHTTP serves the HTTP endpoints on ln until server is closed.
+func serveHTTP(server *http.Server, ln net.Listener) {
+	logPrintf("HTTP server listening on %s", ln.Addr())
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		writeLog(levelError, fmt.Sprintf("HTTP server failed: %v", err))
+	}
+}
@@ -0,0 +1,72 @@
+package watchdog
+
+import (
+	"fmt"
+	"time"
+)
+
+// throughputBaseline keeps a simple moving average of the note rate over the last baseline period,
+// sampled once per window, to notice when throughput suddenly drops below a fraction of it.
+type throughputBaseline struct {
+	window    time.Duration
+	dropRatio float64
+
+	samples     []float64 // Notes per second of each completed window, oldest first
+	size        int       // Windows that make up the baseline
+	windowStart time.Time
+	count       int // Notes in the current window
+}
+
+// throughputDrop reports a window whose rate fell below drop_ratio of the baseline.
+type throughputDrop struct {
+	rate     float64
+	baseline float64
+}
+
+func (d *throughputDrop) Error() string {
+	return fmt.Sprintf("throughput dropped to %.2f notes/s (%.0f%% of the %.2f notes/s baseline)", d.rate, d.rate/d.baseline*100, d.baseline)
+}
+
+// newThroughputBaseline returns nil when throughput.drop_ratio is unset, which disables the check.
+func newThroughputBaseline(cfg *Config, now time.Time) *throughputBaseline {
+	if cfg.Throughput.DropRatio == 0 {
+		return nil
+	}
+	return &throughputBaseline{
+		window:      time.Duration(cfg.Throughput.Window) * time.Second,
+		dropRatio:   cfg.Throughput.DropRatio,
+		size:        max(cfg.Throughput.Baseline/cfg.Throughput.Window, 1),
+		windowStart: now,
+	}
+}
+
+// observe counts a note received at now. Windows that ended before now are closed first, and the
+// first one whose rate is below the ratio is returned once the baseline covers its full period.
+func (b *throughputBaseline) observe(now time.Time) *throughputDrop {
+	var drop *throughputDrop
+	for now.Sub(b.windowStart) >= b.window {
+		rate := float64(b.count) / b.window.Seconds()
+		if avg := b.average(); drop == nil && len(b.samples) == b.size && avg > 0 && rate < avg*b.dropRatio {
+			drop = &throughputDrop{rate: rate, baseline: avg}
+		}
+		b.samples = append(b.samples, rate)
+		if len(b.samples) > b.size {
+			b.samples = b.samples[1:]
+		}
+		b.windowStart = b.windowStart.Add(b.window)
+		b.count = 0
+	}
+	b.count++
+	return drop
+}
+
+func (b *throughputBaseline) average() float64 {
+	if len(b.samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range b.samples {
+		sum += s
+	}
+	return sum / float64(len(b.samples))
+}
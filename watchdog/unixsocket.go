@@ -0,0 +1,48 @@
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// UnixSocketScheme prefixes targets reached through a Unix domain socket,
+// as in ws+unix:///run/misskey/misskey.sock:/streaming.
+const UnixSocketScheme = "ws+unix://"
+
+// parseUnixSocketURL splits a ws+unix URL into the socket path and the request path that follows the first colon.
+// ok is false for any other URL. Without a request path, DefaultPath is used.
+func parseUnixSocketURL(raw string) (socket, requestURI string, ok bool, err error) {
+	rest, ok := strings.CutPrefix(raw, UnixSocketScheme)
+	if !ok {
+		return "", "", false, nil
+	}
+
+	socket, requestURI, found := strings.Cut(rest, ":")
+	if !found {
+		requestURI = DefaultPath
+	}
+	if !filepath.IsAbs(socket) {
+		return "", "", true, fmt.Errorf("%q: the socket path must be absolute (%s/path/to/socket:/streaming)", raw, UnixSocketScheme)
+	}
+	if !strings.HasPrefix(requestURI, "/") {
+		return "", "", true, fmt.Errorf("%q: the request path after the socket must start with /", raw)
+	}
+	return socket, requestURI, true, nil
+}
+
+// unixSocketDialer returns a copy of dialer that connects to socket, whatever host the URL names.
+func unixSocketDialer(dialer *websocket.Dialer, socket string) *websocket.Dialer {
+	d := *dialer
+	d.Proxy = nil // A proxy can't reach a local socket
+	d.NetDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		logDebugf("Dialing unix socket %s", socket)
+		var netDialer net.Dialer
+		return netDialer.DialContext(ctx, "unix", socket)
+	}
+	return &d
+}
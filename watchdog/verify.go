@@ -0,0 +1,108 @@
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/websocket"
+)
+
+// verifyCommand checks that the target delivers notes again after the command ran, for verify_after_command.
+// When it doesn't, the failure is escalated and, with verify_rerun, the command runs once more (and is checked again).
+// It returns the exit code of the last command run. The checks end with ctx, while a rerun gets shutdown like the
+// first run, so that a reload doesn't kill it.
+func (m *monitor) verifyCommand(ctx, shutdown context.Context, category string, exitCode int) int {
+	timeout := time.Duration(m.cfg.VerifyAfterCommand) * time.Second
+	for rerun := false; ; rerun = true {
+		m.logPrintf("Verifying the recovery: waiting up to %s for a note...", timeout)
+		err := m.awaitRecovery(ctx, timeout)
+		if err == nil {
+			m.logPrintf("Verification passed: the timeline is delivering notes again.")
+			return exitCode
+		}
+		if ctx.Err() != nil {
+			return exitCode
+		}
+
+		err = fmt.Errorf("recovery not verified: no note within %s after the command: %w", timeout, err)
+		m.logErrorf("%v", err)
+		m.hub.WithScope(func(scope *sentry.Scope) {
+			scope.SetLevel(sentry.LevelFatal)
+			scope.SetTag("failure_category", CategoryUnrecovered)
+			scope.SetExtra("rerun", rerun)
+			m.hub.CaptureException(err)
+		})
+		m.notify(CategoryUnrecovered, fmt.Sprintf("%scommand did not recover the target: %v", m.prefix, err))
+
+		if rerun || !m.cfg.VerifyRerun {
+			return exitCode
+		}
+		m.logWarnf("Running the command once more (verify_rerun)...")
+		code, ran := m.runCommand(shutdown, category)
+		if !ran {
+			return exitCode
+		}
+		exitCode = code
+	}
+}
+
+// awaitRecovery connects to the target until a note arrives, retrying failed attempts, or timeout elapses.
+func (m *monitor) awaitRecovery(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := m.awaitNote(ctx, m.nodes.pick())
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		m.logDebugf("Verification attempt failed: %v", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(MinCooldown):
+		}
+	}
+}
+
+// awaitNote connects to node, subscribes and waits for one note until ctx is done.
+func (m *monitor) awaitNote(ctx context.Context, node string) error {
+	c, _, err := m.dial(ctx, node)
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", err)
+	}
+	defer c.Close()
+
+	// Unblocks the read when ctx ends; waited for so that nothing outlives the check
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(done)
+	wg.Go(func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = c.Close()
+	})
+
+	if err := newSessionWriter(c, m.cfg).write(websocket.TextMessage, subscribePayload(m.subscribeID)); err != nil {
+		return fmt.Errorf("subscribe request failed: %w", err)
+	}
+	for {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			}
+			return err
+		}
+		if msg, err := parseStreamMessage(data); err == nil && msg.fromSubscription(m.subscribeID) {
+			if _, ok := msg.note(); ok {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package watchdog monitors the global timeline of Misskey instances over the streaming API
+// and runs a recovery command when a timeline goes silent.
+//
+// The misskey-timeline-watchdog command is a thin wrapper around it; other programs can embed it:
+//
+//	cfg, err := watchdog.LoadConfig("config.yaml")
+//	...
+//	w, err := watchdog.New(cfg)
+//	...
+//	w.OnDisconnect = func(ctx context.Context, d watchdog.Disconnect) {
+//		// Restart the instance through an API instead of the configured command
+//	}
+//	err = w.Run(ctx)
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Watchdog monitors every target of its configuration until the context passed to Run is cancelled.
+type Watchdog struct {
+	Hooks // Read by Run and on every reload; set them before calling Run
+
+	cfg     *Config
+	reload  chan *Config
+	started *startups
+	events  *eventLog
+}
+
+// New returns a watchdog for cfg, which must not be modified afterwards. It fails if cfg does not validate.
+func New(cfg *Config) (*Watchdog, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Watchdog{cfg: cfg, reload: make(chan *Config, 1), started: newStartups(), events: newEventLog(cfg.HTTP.EventsSize)}, nil
+}
+
+// Run monitors the targets, serving the HTTP endpoints when http.listen is set, until ctx is cancelled
+// or max_runtime elapses. Both count as a clean shutdown and return nil; it fails right away if http.listen
+// can't be listened on.
+func (w *Watchdog) Run(ctx context.Context) error {
+	cfg := w.cfg
+	var current atomic.Pointer[fleet]
+	current.Store(newFleet(cfg, w.Hooks, w.started, w.events))
+	if cfg.HTTP.Listen != "" {
+		ln, err := net.Listen("tcp", cfg.HTTP.Listen)
+		if err != nil {
+			return fmt.Errorf("http.listen: %w", err)
+		}
+		server := newHTTPServer(cfg, &current)
+		defer server.Close()
+		go serveHTTP(server, ln)
+	}
+
+	if cfg.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, time.Duration(cfg.MaxRuntime)*time.Second, errMaxRuntime)
+		defer cancel()
+	}
+
+	if !waitStartupDelay(ctx, cfg) {
+		logPrintf("Shutting down.")
+		return nil
+	}
+
+	for {
+		runCtx, cancelRun := context.WithCancel(ctx)
+		drainCtx, drain := context.WithCancel(runCtx)
+		wait := current.Load().start(runCtx, drainCtx, ctx)
+
+		var next *Config
+		select {
+		case <-ctx.Done():
+		case next = <-w.reload:
+		}
+		if next != nil && cfg.Reload.Graceful {
+			drainSessions(ctx, drain, wait, time.Duration(cfg.Reload.Timeout)*time.Second)
+		}
+		cancelRun()
+		drain() // A no-op by now, as cancelling runCtx ended drainCtx too
+		wait()
+		if next == nil {
+			break
+		}
+
+		logPrintf("Configuration reloaded. Restarting monitors (http, log and sentry settings need a restart to change).")
+		cfg = next
+		current.Store(newFleet(next, w.Hooks, w.started, w.events))
+	}
+
+	for _, m := range current.Load().monitors {
+		m.logBackoff()
+	}
+	if context.Cause(ctx) == errMaxRuntime {
+		logPrintf("Shutting down: max runtime reached.")
+		return nil
+	}
+	logPrintf("Shutting down.")
+	return nil
+}
+
+// Reload restarts the monitors of a running watchdog with cfg, keeping its http, log and sentry settings.
+// An invalid configuration is rejected and the current one kept. A reload that Run has not picked up yet
+// is replaced by the newer one.
+func (w *Watchdog) Reload(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case w.reload <- cfg:
+			return nil
+		case <-w.reload: // Superseded
+		}
+	}
+}
+
+// drainSessions asks the monitors to stop reconnecting and waits up to timeout for their current sessions
+// to end, or until ctx is cancelled. The reload settings of the running configuration decide how it is replaced.
+func drainSessions(ctx context.Context, drain context.CancelFunc, wait func(), timeout time.Duration) {
+	logPrintf("Configuration reloaded. Waiting up to %s for the current sessions to end (reload.graceful)...", timeout)
+	drain()
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-time.After(timeout):
+		logPrintf("Sessions still open after %s. Disconnecting them.", timeout)
+	}
+}
+
+// SetupSentry initializes the global Sentry client from the sentry section when a DSN is set,
+// and reports whether it did. Events go to every valid DSN of dsn and dsns.
+// Call FlushSentry before exiting to send queued events.
+func SetupSentry(cfg *Config) bool {
+	dsns := sentryDSNs(cfg)
+	if len(dsns) == 0 {
+		return false
+	}
+	options := sentry.ClientOptions{
+		Dsn:              dsns[0],
+		EnableTracing:    cfg.Sentry.Tracing,
+		TracesSampleRate: 1.0,
+		AttachStacktrace: true,
+		// The telemetry buffer transport drops scope attachments, so fall back to the plain HTTP transport when they are used
+		DisableTelemetryBuffer: cfg.Sentry.AttachOutput,
+		BeforeSend: func(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+			sentEvents.Add(1)
+			return event
+		},
+	}
+	if len(dsns) > 1 {
+		// A custom transport also replaces the telemetry buffer, which only knows of one DSN
+		options.Transport = newFanoutTransport(options, dsns)
+	}
+	if err := sentry.Init(options); err != nil {
+		writeLog(levelWarn, fmt.Sprintf("Sentry initialization failed: %v", err))
+		return false
+	}
+	useBreadcrumbs = cfg.Sentry.UseBreadcrumbs
+	sentryFlushTimeout = time.Duration(cfg.Sentry.FlushTimeout) * time.Second
+	if len(dsns) > 1 {
+		logPrintf("Sentry initialized successfully (%d DSNs).", len(dsns))
+		return true
+	}
+	logPrintf("Sentry initialized successfully.")
+	return true
+}
+
+// waitStartupDelay waits startupDelay before the first connection, so watchdogs deployed together
+// don't all connect at once. It returns false if ctx is cancelled first.
+func waitStartupDelay(ctx context.Context, cfg *Config) bool {
+	delay := startupDelay(cfg)
+	if delay <= 0 {
+		return true
+	}
+
+	logPrintf("Waiting %s before connecting (startup_delay)...", delay.Round(time.Millisecond))
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// startupDelay returns startup_delay plus a random share of startup_jitter.
+func startupDelay(cfg *Config) time.Duration {
+	delay := time.Duration(cfg.StartupDelay) * time.Second
+	if cfg.StartupJitter > 0 {
+		delay += time.Duration(random.Int64N(int64(time.Duration(cfg.StartupJitter) * time.Second)))
+	}
+	return delay
+}
+
+// errMaxRuntime is the cancellation cause when max_runtime elapses.
+var errMaxRuntime = errors.New("max runtime reached")